@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sarunask/s3-copy/internal/archive"
+	"github.com/sarunask/s3-copy/internal/copy"
+	"github.com/sarunask/s3-copy/internal/env"
+	"github.com/sarunask/s3-copy/internal/pool"
+	"github.com/sarunask/s3-copy/internal/retry"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// splitBySize sends files smaller than archive threshold, whose CSV row
+// options don't change object, to small and others to large, it closes both
+// when files is closed
+func splitBySize(files <-chan walker.SrcDest, small, large chan<- walker.SrcDest) {
+	defer close(small)
+	defer close(large)
+	for file := range files {
+		if !file.Options.ChangesObject() && int64(file.SourceSize) < env.Settings.ArchiveThreshold {
+			small <- file
+		} else {
+			large <- file
+		}
+	}
+}
+
+// archiveFiles packs files into tar shards under a prefix of this run and
+// uploads index of all packed files next to them. Results of packed files
+// are held back until index is uploaded, as without it they can't be found
+// in shards, so they fail if it can't be uploaded.
+func archiveFiles(up *copy.Uploader, files <-chan walker.SrcDest, results chan<- walker.SrcDest) {
+	if env.Settings.DryRun {
+		for file := range files {
+			results <- file
+		}
+		return
+	}
+	prefix := fmt.Sprintf("%s%s/", env.Settings.ArchivePrefix, time.Now().UTC().Format("20060102T150405Z"))
+	p := &archive.Packer{
+		ShardSize: env.Settings.ArchiveShardSize,
+		ShardKey: func(n int) string {
+			return fmt.Sprintf("%sshard-%06d.tar", prefix, n)
+		},
+		Upload: func(key string, body io.Reader, size int64) error {
+			return up.UploadStream(key, archive.ContentType, body, size)
+		},
+		Retry: newRetryPolicy(),
+	}
+	packed := make(chan walker.SrcDest)
+	var held []walker.SrcDest
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for file := range packed {
+			held = append(held, file)
+		}
+	}()
+	index := p.Run(files, packed)
+	close(packed)
+	<-done
+
+	attempts, class, err := uploadIndex(up, p.Retry, prefix+"index.csv", index)
+	for _, file := range held {
+		if err != nil && file.Error == nil {
+			file.Error = fmt.Errorf("archived in %s, but index of shards wasn't uploaded: %w", file.DstObject, err)
+			file.ErrorClass = string(class)
+			file.Attempts = attempts
+		}
+		results <- file
+	}
+}
+
+// uploadIndex uploads index of archived files to key, if there are any
+func uploadIndex(up *copy.Uploader, policy retry.Policy, key string, index []archive.Entry) (int, retry.Class, error) {
+	if len(index) == 0 {
+		return 0, "", nil
+	}
+	var buf bytes.Buffer
+	if err := archive.WriteIndex(&buf, index); err != nil {
+		return 0, retry.ClassFatal, fmt.Errorf("can't write index of shards: %w", err)
+	}
+	attempts, class, err := policy.Do(func(int) error {
+		return up.UploadStream(key, "text/csv", bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	})
+	if err != nil {
+		log.Errorf("can't upload index of shards to %s: %v", key, err)
+		return attempts, class, fmt.Errorf("can't upload %s: %w", key, err)
+	}
+	log.Infof("index of %d archived files is in %s", len(index), key)
+	return attempts, class, nil
+}
+
+// uploadWithArchive packs files smaller than archive threshold into shards
+// and keeps WorkersCount workers busy with the others. It closes results
+// after last upload is finished.
+func uploadWithArchive(
+	up *copy.Uploader,
+	filesList chan walker.SrcDest,
+	results chan walker.SrcDest,
+) {
+	defer close(results)
+
+	small := make(chan walker.SrcDest)
+	large := make(chan walker.SrcDest)
+	go splitBySize(filesList, small, large)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		archiveFiles(up, small, results)
+	}()
+	pool.Run(env.Settings.WorkersCount, large, results, func(file walker.SrcDest) walker.SrcDest {
+		return runOne("uploading", up.AddFileToS3, file)
+	})
+	wg.Wait()
+}
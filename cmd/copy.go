@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/sarunask/s3-copy/internal/copy"
+	"github.com/sarunask/s3-copy/internal/env"
+	"github.com/sarunask/s3-copy/internal/listing"
+	"github.com/sarunask/s3-copy/internal/transfer"
+)
+
+// copyObjects copies objects from source to destination bucket and prefix
+// or keys from CSV file between those buckets and writes results
+func copyObjects(engine *transfer.Engine) {
+	c := &copy.Copier{
+		S3:                engine.S3,
+		SourceBucket:      env.Settings.SourceBucket,
+		DestinationBucket: env.Settings.DestinationBucket,
+		S3SSEC:            env.Settings.S3SSEC,
+		SourceSSECKey:     env.Settings.SourceSSECKey,
+		S3SSECKey:         env.Settings.S3SSECKey,
+		SSE:               newSSE(),
+		PartSize:          env.Settings.PartSize,
+		PartConcurrency:   env.Settings.PartConcurrency,
+		Retry:             newRetryPolicy(),
+	}
+
+	run(objectsSource(engine.S3, env.Settings.SourceBucket, env.Settings.SourcePrefix, func(key string) string {
+		return listing.DestinationKey(env.Settings.SourcePrefix, env.Settings.DestinationPrefix, key)
+	}), runAll("copying", c.CopyInS3))
+}
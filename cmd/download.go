@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/sarunask/s3-copy/internal/copy"
+	"github.com/sarunask/s3-copy/internal/env"
+	"github.com/sarunask/s3-copy/internal/listing"
+	"github.com/sarunask/s3-copy/internal/mirror"
+	"github.com/sarunask/s3-copy/internal/transfer"
+)
+
+// downloadFiles downloads objects under prefix or from CSV file to path and writes results
+func downloadFiles(engine *transfer.Engine) {
+	down := &copy.Downloader{
+		Client:          engine.Downloader,
+		S3Bucket:        env.Settings.S3Bucket,
+		S3SSEC:          env.Settings.S3SSEC,
+		S3SSECKey:       env.Settings.S3SSECKey,
+		PartSize:        env.Settings.PartSize,
+		PartConcurrency: env.Settings.PartConcurrency,
+		Decryption:      newEncryptionKey(),
+		S3:              engine.S3,
+		Dir:             env.Settings.Path,
+		Retry:           newRetryPolicy(),
+	}
+
+	// objects are listed under prefix like files under directory, as sync does
+	prefix := mirror.DirPrefix(env.Settings.Prefix)
+	run(objectsSource(engine.S3, env.Settings.S3Bucket, prefix, func(key string) string {
+		return listing.LocalPath(env.Settings.Path, prefix, key)
+	}), runAll("downloading", down.GetFileFromS3))
+}
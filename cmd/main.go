@@ -1,83 +1,93 @@
 package main
 
 import (
+	"encoding/csv"
 	"fmt"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
-	"sync"
 
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	log "github.com/sirupsen/logrus"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/sarunask/s3-copy/internal/copy"
+	"github.com/sarunask/s3-copy/internal/envelope"
+	"github.com/sarunask/s3-copy/internal/listing"
+	"github.com/sarunask/s3-copy/internal/pool"
+	"github.com/sarunask/s3-copy/internal/retry"
+	"github.com/sarunask/s3-copy/internal/throttle"
+	"github.com/sarunask/s3-copy/internal/transfer"
 	"github.com/sarunask/s3-copy/internal/walker"
 
 	"github.com/sarunask/s3-copy/internal/env"
 )
 
-func uploadOne(
-	file walker.SrcDest,
-	results chan<- walker.SrcDest,
-	wg *sync.WaitGroup,
-) {
-	defer wg.Done()
+// runOne runs action for file, unless it's dry run, and adds its error
+// described by doing to file
+func runOne(doing string, action func(*walker.SrcDest) error, file walker.SrcDest) walker.SrcDest {
 	if env.Settings.DryRun {
-		return
+		return file
 	}
-	var logLevel aws.LogLevelType
-	if env.Settings.DebugHTTP {
-		logLevel = aws.LogDebugWithHTTPBody
+	err := action(&file)
+	if err != nil {
+		// add error to results
+		file.Error = fmt.Errorf("error %s %s: %w",
+			doing, file.SourceFile, err)
 	}
-	// Create a single AWS session
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region:   aws.String(env.Settings.S3Region),
-		LogLevel: &logLevel,
-	}))
+	// we return file with error or without as success
+	return file
+}
 
-	// Create an uploader with the session and default options
-	up := copy.Uploader{
-		Client:    s3manager.NewUploader(sess),
-		S3Bucket:  env.Settings.S3Bucket,
-		S3SSEC:    env.Settings.S3SSEC,
-		S3SSECKey: env.Settings.S3SSECKey,
-	}
+// source sends files to files and closes it, files which can't be read are
+// sent to results with error
+type source func(files, results chan walker.SrcDest)
 
-	// actually copy files to s3
-	err := up.AddFileToS3(file)
-	if err != nil {
-		// add error to results
-		file.Error = fmt.Errorf("error uploading %s: %w",
-			file.SourceFile, err)
+// work does something with files until files is closed, sends them to
+// results and closes results when it's finished
+type work func(files, results chan walker.SrcDest)
+
+// run does work with files of src and writes results
+func run(src source, w work) {
+	fileList := make(chan walker.SrcDest)
+	results := make(chan walker.SrcDest)
+	// exit is closed by last go routine when it's finished
+	exit := make(chan struct{})
+	go src(fileList, results)
+	go w(fileList, results)
+	go writeOutput(results, exit)
+	<-exit
+}
+
+// runAll returns work, which will keep WorkersCount workers busy with action
+// for files until they are closed and will close results after last action
+// is finished
+func runAll(doing string, action func(*walker.SrcDest) error) work {
+	return func(files, results chan walker.SrcDest) {
+		defer close(results)
+
+		pool.Run(env.Settings.WorkersCount, files, results, func(file walker.SrcDest) walker.SrcDest {
+			return runOne(doing, action, file)
+		})
 	}
-	// we send to results channel file with error or without as success
-	results <- file
 }
 
-func uploadAll(
-	filesList chan walker.SrcDest,
-	results chan walker.SrcDest,
-) {
-	defer close(results)
-
-	var wg sync.WaitGroup
-	goRoutinesCount := 0
-	for filePath := range filesList {
-		wg.Add(1)
-		goRoutinesCount++
-		log.Debugf("%d starting upload of '%#v'",
-			goRoutinesCount, filePath)
-		// add go routine to upload file
-		go uploadOne(filePath, results, &wg)
-		if goRoutinesCount%env.Settings.WorkersCount == 0 {
-			goRoutinesCount = 0
-			log.Warnf("waiting for some goroutines to stop")
-			wg.Wait()
+// hasInputCSV tells if files are read from CSV file instead of path or bucket
+func hasInputCSV() bool {
+	return len(strings.Trim(env.Settings.InputCSVFile, "\n\r\t ")) != 0
+}
+
+// objectsSource returns source of objects from CSV file or, without it, of
+// objects under prefix of bucket, whose destination is returned by dst
+func objectsSource(client s3iface.S3API, bucket, prefix string, dst func(key string) string) source {
+	return func(files, _ chan walker.SrcDest) {
+		if hasInputCSV() {
+			walker.UseObjectsCSVFile(env.Settings.InputCSVFile, files)
+			return
 		}
+		listing.Walk(client, bucket, prefix, dst, files)
 	}
-	wg.Wait()
 }
 
 // closeFile will close file or report error
@@ -97,6 +107,15 @@ func openFile(fName string) *os.File {
 	return f
 }
 
+// formatResult returns CSV record which describes result of file transfer
+func formatResult(res walker.SrcDest) []string {
+	return []string{
+		res.SourceFile, res.DstObject, res.SourceSha256, strconv.FormatUint(res.SourceSize, 10), fmt.Sprint(res.Error),
+		strconv.Itoa(res.Attempts), res.ErrorClass, res.Status, res.AfterAction, res.KeyMD5,
+		res.CompressedSha256, strconv.FormatUint(res.CompressedSize, 10),
+	}
+}
+
 // writeOutput will write output CSV files with results of file upload
 func writeOutput(
 	results chan walker.SrcDest,
@@ -107,16 +126,26 @@ func writeOutput(
 	defer closeFile(success)
 	failure := openFile(env.Settings.OutputFailureFile)
 	defer closeFile(failure)
+	// errors and paths could have commas, quotes and new lines, so they are quoted
+	writers := map[*os.File]*csv.Writer{
+		success: csv.NewWriter(success),
+		failure: csv.NewWriter(failure),
+	}
 	// wait for new record to add or for exit
 	for res := range results {
 		out := success
 		if res.Error != nil {
 			out = failure
 		}
-		log.Debugf("writing {%s} to %s",
-			fmt.Sprintf("%s,%s,%s,%d,%v\n", res.SourceFile, res.DstObject, res.SourceSha256, res.SourceSize, res.Error),
-			out.Name())
-		_, err := out.WriteString(fmt.Sprintf("%s,%s,%s,%d,%v\n", res.SourceFile, res.DstObject, res.SourceSha256, res.SourceSize, res.Error))
+		rec := formatResult(res)
+		log.Debugf("writing %q to %s", rec, out.Name())
+		w := writers[out]
+		// records are flushed one by one, so results are kept if upload is interrupted
+		err := w.Write(rec)
+		if err == nil {
+			w.Flush()
+			err = w.Error()
+		}
 		if err != nil {
 			log.Errorf("can't write to %s: %v", out.Name(), err)
 		}
@@ -125,6 +154,130 @@ func writeOutput(
 	close(exit)
 }
 
+// newLimiter returns limiter for rate or nil if rate isn't limited
+func newLimiter(rate, burst int64) *throttle.Limiter {
+	if rate == 0 {
+		return nil
+	}
+	return throttle.NewLimiter(rate, burst)
+}
+
+// newObjectOptions returns headers of uploaded objects configured by flags
+func newObjectOptions() copy.ObjectOptions {
+	opts := copy.ObjectOptions{
+		ContentDisposition: env.Settings.ContentDisposition,
+		CacheControl:       env.Settings.CacheControl,
+		ContentEncoding:    env.Settings.ContentEncoding,
+		ContentLanguage:    env.Settings.ContentLanguage,
+		Expires:            env.Settings.Expires,
+		StorageClass:       env.Settings.StorageClass,
+		ACL:                env.Settings.ACL,
+		Tags:               env.Settings.Tags,
+		Metadata:           env.Settings.Metadata,
+	}
+	if len(env.Settings.ContentTypeMap) != 0 {
+		types, err := copy.ReadContentTypes(env.Settings.ContentTypeMap)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		opts.ContentTypes = types
+	}
+	return opts
+}
+
+// newSSE returns server-side encryption configured by flags
+func newSSE() copy.SSE {
+	return copy.SSE{
+		Algorithm:        env.Settings.SSE,
+		KMSKeyID:         env.Settings.SSEKMSKeyID,
+		KMSContext:       env.Settings.SSEKMSContext,
+		BucketKeyEnabled: env.Settings.BucketKeyEnabled,
+	}
+}
+
+// newEncryptionKey returns master key of client-side encryption or nil if it's disabled
+func newEncryptionKey() *envelope.Key {
+	if len(env.Settings.CSEKey) == 0 {
+		return nil
+	}
+	key, err := envelope.NewKey(env.Settings.CSEKey)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return key
+}
+
+// newCompression returns compression configured by flags or nil if files aren't compressed
+func newCompression() *copy.Compression {
+	if len(env.Settings.Compress) == 0 {
+		return nil
+	}
+	return &copy.Compression{
+		Level:   env.Settings.CompressLevel,
+		Include: env.Settings.CompressInclude,
+		Exclude: env.Settings.CompressExclude,
+		Suffix:  env.Settings.CompressSuffix,
+	}
+}
+
+// newRetryPolicy returns retry policy configured by flags
+func newRetryPolicy() retry.Policy {
+	return retry.Policy{
+		MaxAttempts: env.Settings.RetryMaxAttempts,
+		BaseDelay:   env.Settings.RetryBaseDelay,
+		MaxDelay:    env.Settings.RetryMaxDelay,
+		Jitter:      env.Settings.RetryJitter,
+	}
+}
+
+// newUploader returns uploader configured by flags
+func newUploader(engine *transfer.Engine) *copy.Uploader {
+	return &copy.Uploader{
+		Client:            engine.Uploader,
+		S3:                engine.S3,
+		S3Bucket:          env.Settings.S3Bucket,
+		S3SSEC:            env.Settings.S3SSEC,
+		S3SSECKey:         env.Settings.S3SSECKey,
+		SSE:               newSSE(),
+		PartSize:          env.Settings.PartSize,
+		PartConcurrency:   env.Settings.PartConcurrency,
+		Bandwidth:         newLimiter(env.Settings.MaxBandwidth, env.Settings.MaxBandwidthBurst),
+		SkipExisting:      copy.SkipMode(env.Settings.SkipExisting),
+		ChecksumAlgorithm: env.Settings.ChecksumAlgorithm,
+		Verify:            env.Settings.Verify,
+		Object:            newObjectOptions(),
+		Encryption:        newEncryptionKey(),
+		Compression:       newCompression(),
+		After: copy.AfterUpload{
+			Action: env.Settings.AfterUpload,
+			Dir:    env.Settings.AfterUploadDir,
+		},
+		Retry: newRetryPolicy(),
+	}
+}
+
+// uploadFiles uploads files from path or CSV file and writes results
+func uploadFiles(engine *transfer.Engine) {
+	up := newUploader(engine)
+	walker.SetHashLimiter(newLimiter(env.Settings.MaxHashBandwidth, 0))
+	walker.SetKeyEncoding(env.Settings.SSECKeyEncoding)
+
+	src := func(files, results chan walker.SrcDest) {
+		if hasInputCSV() {
+			walker.UseCSVFile(env.Settings.InputCSVFile, files, results, env.Settings.NewerThan)
+			return
+		}
+		walker.Walk(env.Settings.Path, files, results, env.Settings.Exclude, env.Settings.NewerThan)
+	}
+	w := runAll("uploading", up.AddFileToS3)
+	if env.Settings.ArchiveThreshold > 0 {
+		w = func(files, results chan walker.SrcDest) {
+			uploadWithArchive(up, files, results)
+		}
+	}
+	run(src, w)
+}
+
 func main() {
 	// Use more CPU's when available
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -139,17 +292,41 @@ func main() {
 		log.SetLevel(log.DebugLevel)
 	}
 
-	fileList := make(chan walker.SrcDest)
-	results := make(chan walker.SrcDest)
-	// exit is closed by last go routine when it's finished
-	exit := make(chan struct{})
-	if len(strings.Trim(env.Settings.InputCSVFile, "\n\r\t ")) != 0 {
-		go walker.UseCSVFile(env.Settings.InputCSVFile, fileList, results, env.Settings.NewerThan)
-	} else {
-		go walker.Walk(env.Settings.Path, fileList, results, env.Settings.Exclude, env.Settings.NewerThan)
+	// Create a single transfer engine, which is shared by all workers
+	engine, err := transfer.New(transfer.Options{
+		Region:         env.Settings.S3Region,
+		DebugHTTP:      env.Settings.DebugHTTP,
+		MaxConns:       env.Settings.WorkersCount * env.Settings.PartConcurrency,
+		EndpointURL:    env.Settings.EndpointURL,
+		ForcePathStyle: env.Settings.ForcePathStyle,
+		DisableSSL:     env.Settings.DisableSSL,
+		CABundle:       env.Settings.CABundle,
+		Profile:        env.Settings.Profile,
+		Role: transfer.Role{
+			ARN:         env.Settings.RoleARN,
+			ExternalID:  env.Settings.ExternalID,
+			SessionName: env.Settings.RoleSessionName,
+			Duration:    env.Settings.RoleDuration,
+			MFASerial:   env.Settings.MFASerial,
+		},
+		TokenProvider: stscreds.StdinTokenProvider,
+	})
+	if err != nil {
+		log.Fatalf("can't create transfer engine: %v", err)
+	}
+	switch env.Settings.Command {
+	case env.CommandMultipart:
+		cleanMultipart(engine)
+	case env.CommandDownload:
+		downloadFiles(engine)
+	case env.CommandCopy:
+		copyObjects(engine)
+	case env.CommandRotateKey:
+		rotateKeys(engine)
+	case env.CommandSync:
+		syncFiles(engine)
+	default:
+		uploadFiles(engine)
 	}
-	go uploadAll(fileList, results)
-	go writeOutput(results, exit)
-	<-exit
 	log.Debugf("done - exiting")
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sarunask/s3-copy/internal/env"
+	"github.com/sarunask/s3-copy/internal/multipart"
+	"github.com/sarunask/s3-copy/internal/transfer"
+)
+
+// abortUpload aborts upload unless it's dry run and returns what was done
+func abortUpload(c *multipart.Cleaner, u multipart.Upload) string {
+	if env.Settings.DryRun {
+		return "abort (dry run)"
+	}
+	if err := c.Abort(u); err != nil {
+		log.Errorf("%v", err)
+		return "abort failed"
+	}
+	return "aborted"
+}
+
+// cleanMultipart lists multipart uploads in progress under prefix and aborts
+// those which are older than older-than or match key-regex. On dry run
+// nothing is aborted, but we still show what would be.
+func cleanMultipart(engine *transfer.Engine) {
+	c := multipart.Cleaner{
+		S3:     engine.S3,
+		Bucket: env.Settings.S3Bucket,
+	}
+	uploads, err := c.List(env.Settings.Prefix)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	filter := multipart.Filter{
+		OlderThan: env.Settings.OlderThan,
+		KeyRegex:  env.Settings.KeyRegex,
+	}
+	now := time.Now()
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tUPLOAD ID\tINITIATED\tAGE\tINITIATOR\tPARTS\tBYTES\tACTION")
+	for _, u := range uploads {
+		action := "keep"
+		if filter.Match(u, now) {
+			action = abortUpload(&c, u)
+			if action == "abort failed" {
+				failed++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%s\t%d\t%d\t%s\n",
+			u.Key, u.UploadID, u.Initiated.Format(time.RFC3339), u.Age(now).Truncate(time.Second),
+			u.Initiator, u.Parts, u.Bytes, action)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("can't write uploads list: %v", err)
+	}
+	if failed > 0 {
+		log.Fatalf("failed to abort %d of %d uploads", failed, len(uploads))
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/sarunask/s3-copy/internal/copy"
+	"github.com/sarunask/s3-copy/internal/env"
+	"github.com/sarunask/s3-copy/internal/transfer"
+)
+
+// rotateKeys re-encrypts objects under prefix or from CSV file with the new
+// SSE-C key and writes results
+func rotateKeys(engine *transfer.Engine) {
+	c := &copy.Copier{
+		S3:                engine.S3,
+		SourceBucket:      env.Settings.S3Bucket,
+		DestinationBucket: env.Settings.S3Bucket,
+		S3SSEC:            env.Settings.S3SSEC,
+		SourceSSECKey:     env.Settings.SourceSSECKey,
+		S3SSECKey:         env.Settings.S3SSECKey,
+		PartSize:          env.Settings.PartSize,
+		PartConcurrency:   env.Settings.PartConcurrency,
+		Retry:             newRetryPolicy(),
+	}
+
+	run(objectsSource(engine.S3, env.Settings.S3Bucket, env.Settings.Prefix, func(key string) string {
+		return key
+	}), runAll("rotating key of", c.RotateKey))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sarunask/s3-copy/internal/copy"
+	"github.com/sarunask/s3-copy/internal/env"
+	"github.com/sarunask/s3-copy/internal/listing"
+	"github.com/sarunask/s3-copy/internal/mirror"
+	"github.com/sarunask/s3-copy/internal/pool"
+	"github.com/sarunask/s3-copy/internal/retry"
+	"github.com/sarunask/s3-copy/internal/transfer"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// syncKeys gives walked files their keys under prefix and records keys of
+// their objects in seen. Walk errors are forwarded to results and reported
+// by return value.
+func syncKeys(
+	prefix string,
+	objectKey func(*walker.SrcDest) string,
+	walked <-chan walker.SrcDest,
+	walkErrors <-chan walker.SrcDest,
+	filesList chan<- walker.SrcDest,
+	results chan<- walker.SrcDest,
+	seen map[string]bool,
+) (walkFailed bool) {
+	defer close(filesList)
+	for {
+		select {
+		case file, ok := <-walked:
+			if !ok {
+				// Walk sends all errors before it closes files channel
+				return walkFailed
+			}
+			file.DstObject = mirror.Key(env.Settings.Path, prefix, file.SourceFile)
+			seen[objectKey(&file)] = true
+			filesList <- file
+		case file := <-walkErrors:
+			walkFailed = true
+			results <- file
+		}
+	}
+}
+
+// deleteExtraneous deletes objects under prefix, which have no local file,
+// if it's allowed and there are not more of them than max-delete
+func deleteExtraneous(
+	engine *transfer.Engine,
+	prefix string,
+	remote map[string]int64,
+	seen map[string]bool,
+	walkFailed bool,
+	results chan<- walker.SrcDest,
+) {
+	keys := mirror.Extraneous(remote, seen, env.Settings.Path, prefix)
+	if len(keys) == 0 {
+		return
+	}
+	if !env.Settings.Delete {
+		log.Infof("%d objects under '%s' have no local files, use --delete to delete them", len(keys), prefix)
+		return
+	}
+	result := func(key string) walker.SrcDest {
+		return walker.SrcDest{
+			SourceFile: listing.LocalPath(env.Settings.Path, prefix, key),
+			SourceSize: uint64(remote[key]),
+			DstObject:  key,
+		}
+	}
+	var reason error
+	switch {
+	case walkFailed:
+		reason = fmt.Errorf("not deleted, as not all local files could be read")
+	case len(keys) > env.Settings.MaxDelete:
+		reason = fmt.Errorf("not deleted, as %d objects would be deleted and max-delete is %d",
+			len(keys), env.Settings.MaxDelete)
+	}
+	if reason != nil {
+		log.Errorf("%v", reason)
+		for _, key := range keys {
+			res := result(key)
+			res.Error = reason
+			res.ErrorClass = string(retry.ClassFatal)
+			results <- res
+		}
+		return
+	}
+	if env.Settings.DryRun {
+		for _, key := range keys {
+			log.Infof("would delete %s", key)
+			res := result(key)
+			res.Status = "delete (dry run)"
+			results <- res
+		}
+		return
+	}
+	d := mirror.Deleter{
+		S3:     engine.S3,
+		Bucket: env.Settings.S3Bucket,
+		Retry:  newRetryPolicy(),
+	}
+	d.Delete(keys, func(key string, err error) {
+		res := result(key)
+		if err != nil {
+			res.Error = err
+			res.ErrorClass = string(retry.Classify(err))
+		} else {
+			log.Infof("deleted %s", key)
+			res.Status = walker.StatusDeleted
+		}
+		results <- res
+	})
+}
+
+// syncFiles mirrors path to prefix: new files and files with different size
+// or SHA-256 are uploaded and objects without local files could be deleted
+func syncFiles(engine *transfer.Engine) {
+	prefix := mirror.DirPrefix(env.Settings.Prefix)
+	remote, err := mirror.Remote(engine.S3, env.Settings.S3Bucket, prefix)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	up := newUploader(engine)
+	// objects with the same size are checked by SHA-256 before upload
+	check := *up
+	check.SkipExisting = copy.SkipChecksum
+	walker.SetHashLimiter(newLimiter(env.Settings.MaxHashBandwidth, 0))
+
+	walked := make(chan walker.SrcDest)
+	walkErrors := make(chan walker.SrcDest)
+	fileList := make(chan walker.SrcDest)
+	results := make(chan walker.SrcDest)
+	// exit is closed by last go routine when it's finished
+	exit := make(chan struct{})
+	seen := map[string]bool{}
+	walkFailed := make(chan bool, 1)
+	go walker.Walk(env.Settings.Path, walked, walkErrors, env.Settings.Exclude, env.Settings.NewerThan)
+	go func() {
+		walkFailed <- syncKeys(prefix, up.ObjectKey, walked, walkErrors, fileList, results, seen)
+	}()
+	go func() {
+		defer close(results)
+		pool.Run(env.Settings.WorkersCount, fileList, results, func(file walker.SrcDest) walker.SrcDest {
+			size, ok := remote[up.ObjectKey(&file)]
+			// size of compressed object isn't known before upload
+			if ok && (up.Compresses(file.SourceFile) || size == up.ObjectSize(int64(file.SourceSize))) {
+				return runOne("uploading", check.AddFileToS3, file)
+			}
+			return runOne("uploading", up.AddFileToS3, file)
+		})
+		deleteExtraneous(engine, prefix, remote, seen, <-walkFailed, results)
+	}()
+	go writeOutput(results, exit)
+	<-exit
+}
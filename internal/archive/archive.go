@@ -0,0 +1,238 @@
+package archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sarunask/s3-copy/internal/retry"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// ContentType of shards
+const ContentType = "application/x-tar"
+
+// blockSize is size of tar header and the unit its content is padded to
+const blockSize = 512
+
+// endSize is size of two zero blocks, which end tar
+const endSize = 2 * blockSize
+
+// IndexHeader is header of CSV index
+var IndexHeader = []string{"path", "name", "shard", "offset", "size", "sha256"}
+
+// Entry tells where content of file is in shard, so it could be read with
+// ranged GET of Size bytes from Offset
+type Entry struct {
+	// Path is local path of file
+	Path string
+	// Name is name of file in tar
+	Name   string
+	Shard  string
+	Offset int64
+	Size   int64
+	SHA256 string
+}
+
+// WriteIndex writes entries as CSV with IndexHeader
+func WriteIndex(w io.Writer, entries []Entry) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(IndexHeader); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		rec := []string{e.Path, e.Name, e.Shard, strconv.FormatInt(e.Offset, 10), strconv.FormatInt(e.Size, 10), e.SHA256}
+		if err := out.Write(rec); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// entrySize returns how many bytes file of size takes in tar with short name
+func entrySize(size int64) int64 {
+	return blockSize + (size+blockSize-1)/blockSize*blockSize
+}
+
+// Packer packs files into tar shards and uploads them
+type Packer struct {
+	// ShardSize is target size of shard, it's exceeded only by shard with single file
+	ShardSize int64
+	// ShardKey returns key of shard n
+	ShardKey func(n int) string
+	// Upload uploads shard with key of about size bytes, it should read
+	// body until EOF or error
+	Upload func(key string, body io.Reader, size int64) error
+	Retry  retry.Policy
+}
+
+// Run packs files into shards until files is closed. Every file is sent to
+// results with StatusArchived and key of its shard or with error. It returns
+// index of all archived files.
+func (p *Packer) Run(files <-chan walker.SrcDest, results chan<- walker.SrcDest) []Entry {
+	var (
+		index []Entry
+		batch []walker.SrcDest
+		size  int64
+		n     int
+	)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		index = append(index, p.ship(p.ShardKey(n), batch, size+endSize, results)...)
+		batch, size = nil, 0
+		n++
+	}
+	for file := range files {
+		s := entrySize(int64(file.SourceSize))
+		if len(batch) != 0 && size+s+endSize > p.ShardSize {
+			flush()
+		}
+		batch = append(batch, file)
+		size += s
+	}
+	flush()
+	return index
+}
+
+// packed is result of writing shard
+type packed struct {
+	entries []Entry
+	err     error
+}
+
+// ship uploads batch as shard with key and sends results of its files. Tar
+// is written while it's uploaded, so every attempt writes it again.
+func (p *Packer) ship(key string, batch []walker.SrcDest, size int64, results chan<- walker.SrcDest) []Entry {
+	var entries []Entry
+	attempts, class, err := p.Retry.Do(func(attempt int) error {
+		if attempt > 1 {
+			log.Infof("attempt %d to upload shard %v", attempt, key)
+		}
+		pr, pw := io.Pipe()
+		done := make(chan packed, 1)
+		go func() {
+			entries, err := write(pw, key, batch)
+			pw.CloseWithError(err)
+			done <- packed{entries: entries, err: err}
+		}()
+		err := p.Upload(key, pr, size)
+		// writer shouldn't be blocked, if upload stopped reading
+		pr.Close()
+		res := <-done
+		// pipe is closed for writer, when upload fails, so its own errors come first
+		if res.err != nil && !errors.Is(res.err, io.ErrClosedPipe) {
+			return res.err
+		}
+		if err != nil {
+			return fmt.Errorf("failed to upload shard %v: %w", key, err)
+		}
+		if res.err != nil {
+			return res.err
+		}
+		entries = res.entries
+		return nil
+	})
+	if err == nil {
+		log.Infof("successfuly uploaded %d files in shard %v", len(batch), key)
+	}
+	for _, file := range batch {
+		file.DstObject = key
+		file.Attempts = attempts
+		file.ErrorClass = string(class)
+		if err != nil {
+			file.Error = fmt.Errorf("error archiving %s: %w", file.SourceFile, err)
+		} else {
+			file.Status = walker.StatusArchived
+		}
+		results <- file
+	}
+	return entries
+}
+
+// write writes batch as tar to w and returns where files are in it
+func write(w io.Writer, key string, batch []walker.SrcDest) ([]Entry, error) {
+	cw := &countingWriter{w: w}
+	tw := tar.NewWriter(cw)
+	entries := make([]Entry, 0, len(batch))
+	for _, file := range batch {
+		entry, err := add(tw, cw, file)
+		if err != nil {
+			return nil, err
+		}
+		entry.Shard = key
+		entries = append(entries, entry)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// add writes file to tw, file shouldn't be changed since it was hashed
+func add(tw *tar.Writer, cw *countingWriter, file walker.SrcDest) (Entry, error) {
+	f, err := os.Open(file.SourceFile)
+	if err != nil {
+		return Entry{}, fmt.Errorf("can't open %v: %w", file.SourceFile, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Entry{}, fmt.Errorf("can't get info for %v: %w", file.SourceFile, err)
+	}
+	size := int64(file.SourceSize)
+	if info.Size() != size {
+		return Entry{}, fmt.Errorf("%v has %d bytes instead of %d, it was changed since it was hashed",
+			file.SourceFile, info.Size(), size)
+	}
+	name := strings.TrimLeft(file.DstObject, "/")
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  info.ModTime(),
+	})
+	if err != nil {
+		return Entry{}, fmt.Errorf("can't add %v: %w", file.SourceFile, err)
+	}
+	offset := cw.n
+	h := sha256.New()
+	if _, err := io.CopyN(tw, io.TeeReader(f, h), size); err != nil {
+		return Entry{}, fmt.Errorf("can't add %v: %w", file.SourceFile, err)
+	}
+	sum := fmt.Sprintf("%x", h.Sum(nil))
+	if len(file.SourceSha256) != 0 && sum != file.SourceSha256 {
+		return Entry{}, fmt.Errorf("%v has SHA-256 %s instead of %s, it was changed since it was hashed",
+			file.SourceFile, sum, file.SourceSha256)
+	}
+	return Entry{
+		Path:   file.SourceFile,
+		Name:   name,
+		Offset: offset,
+		Size:   size,
+		SHA256: sum,
+	}, nil
+}
+
+// countingWriter counts bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
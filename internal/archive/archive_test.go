@@ -0,0 +1,153 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/retry"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// testFiles creates count files of size bytes and returns them as walked
+func testFiles(t *testing.T, count, size int) []walker.SrcDest {
+	dir := t.TempDir()
+	files := make([]walker.SrcDest, 0, count)
+	for i := 0; i < count; i++ {
+		content := bytes.Repeat([]byte{byte('a' + i)}, size)
+		path := filepath.Join(dir, fmt.Sprintf("file%d", i))
+		assert.NoError(t, os.WriteFile(path, content, 0600))
+		files = append(files, walker.SrcDest{
+			SourceFile:   path,
+			SourceSha256: fmt.Sprintf("%x", sha256.Sum256(content)),
+			SourceSize:   uint64(size),
+			DstObject:    fmt.Sprintf("/dir/file%d", i),
+		})
+	}
+	return files
+}
+
+// run packs files and returns uploaded shards, results and index
+func run(p *Packer, files []walker.SrcDest) (map[string][]byte, []walker.SrcDest, []Entry) {
+	shards := map[string][]byte{}
+	upload := p.Upload
+	p.Upload = func(key string, body io.Reader, size int64) error {
+		if upload != nil {
+			if err := upload(key, body, size); err != nil {
+				return err
+			}
+		}
+		content, err := io.ReadAll(body)
+		shards[key] = content
+		return err
+	}
+	in := make(chan walker.SrcDest, len(files))
+	for _, file := range files {
+		in <- file
+	}
+	close(in)
+	results := make(chan walker.SrcDest, len(files))
+	index := p.Run(in, results)
+	close(results)
+	var out []walker.SrcDest
+	for res := range results {
+		out = append(out, res)
+	}
+	return shards, out, index
+}
+
+func TestPackerRun(t *testing.T) {
+	files := testFiles(t, 5, 1000)
+	p := &Packer{
+		// 2 files of 1000 bytes with headers and end of tar fit
+		ShardSize: 2*(blockSize+1024) + endSize,
+		ShardKey:  func(n int) string { return fmt.Sprintf("shards/%d.tar", n) },
+	}
+	shards, results, index := run(p, files)
+	assert.Len(t, shards, 3)
+	assert.Len(t, results, 5)
+	assert.Len(t, index, 5)
+	for i, res := range results {
+		msg := fmt.Sprintf("they should be equal in iteration %d", i)
+		assert.NoError(t, res.Error, msg)
+		assert.Equal(t, walker.StatusArchived, res.Status, msg)
+		assert.Equal(t, fmt.Sprintf("shards/%d.tar", i/2), res.DstObject, msg)
+	}
+	for i, e := range index {
+		msg := fmt.Sprintf("they should be equal in iteration %d", i)
+		assert.Equal(t, files[i].SourceFile, e.Path, msg)
+		assert.Equal(t, fmt.Sprintf("dir/file%d", i), e.Name, msg)
+		assert.Equal(t, files[i].SourceSha256, e.SHA256, msg)
+		// ranged GET of shard returns the file
+		content := shards[e.Shard][e.Offset : e.Offset+e.Size]
+		assert.Equal(t, strings.Repeat(string(rune('a'+i)), 1000), string(content), msg)
+	}
+	// shards are valid tar files
+	tr := tar.NewReader(bytes.NewReader(shards["shards/2.tar"]))
+	hdr, err := tr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "dir/file4", hdr.Name)
+	_, err = tr.Next()
+	assert.Equal(t, io.EOF, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteIndex(&buf, index[:1]))
+	assert.Equal(t, fmt.Sprintf("path,name,shard,offset,size,sha256\n%s,dir/file0,shards/0.tar,512,1000,%s\n",
+		files[0].SourceFile, files[0].SourceSha256), buf.String())
+}
+
+func TestPackerRetries(t *testing.T) {
+	files := testFiles(t, 2, 10)
+	calls := 0
+	p := &Packer{
+		ShardSize: 1024 * 1024,
+		ShardKey:  func(n int) string { return "shard.tar" },
+		Upload: func(key string, body io.Reader, size int64) error {
+			assert.Equal(t, int64(blockSize*4+endSize), size)
+			calls++
+			if calls == 1 {
+				// tar is partially read before upload fails
+				_, _ = body.Read(make([]byte, 10))
+				return awserr.New("RequestTimeout", "timeout", nil)
+			}
+			return nil
+		},
+		Retry: retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+	}
+	shards, results, index := run(p, files)
+	assert.Equal(t, 2, calls)
+	assert.Len(t, index, 2)
+	assert.Len(t, shards["shard.tar"], blockSize*4+endSize)
+	for _, res := range results {
+		assert.Equal(t, 2, res.Attempts)
+		assert.Equal(t, walker.StatusArchived, res.Status)
+	}
+}
+
+func TestPackerChangedFile(t *testing.T) {
+	files := testFiles(t, 2, 10)
+	assert.NoError(t, os.WriteFile(files[1].SourceFile, []byte("changed!!!"), 0600))
+	p := &Packer{
+		ShardSize: 1024 * 1024,
+		ShardKey:  func(n int) string { return "shard.tar" },
+		Retry:     retry.Policy{MaxAttempts: 3},
+	}
+	_, results, index := run(p, files)
+	assert.Empty(t, index)
+	for _, res := range results {
+		assert.ErrorContains(t, res.Error, "changed since it was hashed")
+		assert.Equal(t, 1, res.Attempts)
+		assert.Equal(t, string(retry.ClassFatal), res.ErrorClass)
+		assert.Empty(t, res.Status)
+	}
+}
@@ -0,0 +1,123 @@
+package copy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sarunask/s3-copy/internal/listing"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// Actions with local file after it was uploaded
+const (
+	AfterKeep   = "keep"
+	AfterDelete = "delete"
+	AfterMove   = "move"
+)
+
+// AfterUpload tells what to do with local file after it was uploaded and verified
+type AfterUpload struct {
+	// Action is one of AfterKeep, AfterDelete or AfterMove, empty means AfterKeep
+	Action string
+	// Dir is where files are moved, they keep their destination key as path in it
+	Dir string
+}
+
+// unchangedSinceHashed checks that file has the same size and modification
+// time as when walker hashed it, so uploaded object has its current content
+func unchangedSinceHashed(file *walker.SrcDest) error {
+	info, err := os.Stat(file.SourceFile)
+	if err != nil {
+		return err
+	}
+	if uint64(info.Size()) != file.SourceSize {
+		return fmt.Errorf("size of %s changed from %d to %d since it was hashed",
+			file.SourceFile, file.SourceSize, info.Size())
+	}
+	if !file.SourceModTime.IsZero() && !info.ModTime().Equal(file.SourceModTime) {
+		return fmt.Errorf("%s was modified at %v since it was hashed",
+			file.SourceFile, info.ModTime())
+	}
+	return nil
+}
+
+// moveFile moves src to dst, which shouldn't exist. It's linked and
+// removed, so existing dst is never replaced, and copied when they are on
+// different file systems.
+func moveFile(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		return os.Remove(src)
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return existError(err, dst)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return existError(err, dst)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// existError describes err of creating dst, which already exists
+func existError(err error, dst string) error {
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%v already exists: %w", dst, err)
+	}
+	return err
+}
+
+// afterUpload deletes or moves uploaded file and records what was done
+func (u *Uploader) afterUpload(file *walker.SrcDest) error {
+	switch u.After.Action {
+	case "", AfterKeep:
+		return nil
+	case AfterDelete, AfterMove:
+	default:
+		return fmt.Errorf("unknown after upload action '%s'", u.After.Action)
+	}
+	if err := unchangedSinceHashed(file); err != nil {
+		return fmt.Errorf("refusing to %s uploaded file: %w", u.After.Action, err)
+	}
+	if u.After.Action == AfterDelete {
+		if err := os.Remove(file.SourceFile); err != nil {
+			return fmt.Errorf("can't delete uploaded file: %w", err)
+		}
+		log.Infof("deleted %v after upload", file.SourceFile)
+		file.AfterAction = "deleted"
+		return nil
+	}
+	dst := filepath.Join(u.After.Dir, filepath.FromSlash(file.DstObject))
+	if err := listing.Contained(u.After.Dir, dst); err != nil {
+		return fmt.Errorf("refusing to move uploaded file: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("can't create directory for %v: %w", dst, err)
+	}
+	if err := moveFile(file.SourceFile, dst); err != nil {
+		return fmt.Errorf("can't move uploaded file to %v: %w", dst, err)
+	}
+	log.Infof("moved %v to %v after upload", file.SourceFile, dst)
+	file.AfterAction = "moved:" + dst
+	return nil
+}
@@ -0,0 +1,145 @@
+package copy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// hashedFile creates file in dir and describes it the way walker does
+func hashedFile(t *testing.T, dir string) walker.SrcDest {
+	path := filepath.Join(dir, "log.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("123456789"), 0600))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	return walker.SrcDest{
+		SourceFile:    path,
+		SourceSha256:  "15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225",
+		SourceSize:    uint64(info.Size()),
+		SourceModTime: info.ModTime(),
+		DstObject:     "logs/log.txt",
+	}
+}
+
+func TestAddFileToS3AfterUpload(t *testing.T) {
+	cases := []struct {
+		After  AfterUpload
+		Change func(path string)
+		Verify bool
+		Kept   bool
+		Moved  bool
+		Fail   bool
+	}{
+		{After: AfterUpload{Action: AfterKeep}, Kept: true},
+		{After: AfterUpload{Action: AfterDelete}},
+		{After: AfterUpload{Action: AfterMove}, Moved: true},
+		{
+			After: AfterUpload{Action: AfterDelete},
+			Change: func(path string) {
+				assert.NoError(t, os.WriteFile(path, []byte("1234567890"), 0600))
+			},
+			Kept: true,
+			Fail: true,
+		},
+		{
+			After: AfterUpload{Action: AfterDelete},
+			Change: func(path string) {
+				later := time.Now().Add(time.Hour)
+				assert.NoError(t, os.Chtimes(path, later, later))
+			},
+			Kept: true,
+			Fail: true,
+		},
+		{
+			// object isn't in S3, so verification fails and file is kept
+			After:  AfterUpload{Action: AfterDelete},
+			Verify: true,
+			Kept:   true,
+			Fail:   true,
+		},
+	}
+	for i, c := range cases {
+		dir := t.TempDir()
+		file := hashedFile(t, dir)
+		if c.Change != nil {
+			c.Change(file.SourceFile)
+		}
+		if c.After.Action == AfterMove {
+			c.After.Dir = filepath.Join(dir, "archive")
+		}
+		u := Uploader{
+			Client:   &flakyS3Manager{},
+			S3:       &mockS3{Objects: map[string]*s3.HeadObjectOutput{}},
+			S3Bucket: "bucket",
+			After:    c.After,
+			Verify:   c.Verify,
+		}
+		err := u.AddFileToS3(&file)
+		msg := fmt.Sprintf("they should be equal in iteration %d", i)
+		assert.Equal(t, c.Fail, err != nil, msg)
+		_, err = os.Stat(file.SourceFile)
+		assert.Equal(t, c.Kept, err == nil, msg)
+		moved := filepath.Join(dir, "archive", "logs", "log.txt")
+		_, err = os.Stat(moved)
+		assert.Equal(t, c.Moved, err == nil, msg)
+		switch {
+		case c.Moved:
+			assert.Equal(t, "moved:"+moved, file.AfterAction, msg)
+		case !c.Kept:
+			assert.Equal(t, "deleted", file.AfterAction, msg)
+		default:
+			assert.Empty(t, file.AfterAction, msg)
+		}
+	}
+}
+
+func TestAddFileToS3AfterUploadOutside(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := hashedFile(t, dir)
+	file.DstObject = "../escaped/log.txt"
+	u := Uploader{
+		Client:   &flakyS3Manager{},
+		S3:       &mockS3{Objects: map[string]*s3.HeadObjectOutput{}},
+		S3Bucket: "bucket",
+		After:    AfterUpload{Action: AfterMove, Dir: filepath.Join(dir, "archive")},
+	}
+	assert.ErrorContains(t, u.AddFileToS3(&file), "outside of")
+	_, err := os.Stat(file.SourceFile)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "escaped", "log.txt"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, file.AfterAction)
+}
+
+func TestAddFileToS3AfterUploadCollision(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := hashedFile(t, dir)
+	moved := filepath.Join(dir, "archive", "logs", "log.txt")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(moved), 0755))
+	assert.NoError(t, os.WriteFile(moved, []byte("older"), 0600))
+	u := Uploader{
+		Client:   &flakyS3Manager{},
+		S3:       &mockS3{Objects: map[string]*s3.HeadObjectOutput{}},
+		S3Bucket: "bucket",
+		After:    AfterUpload{Action: AfterMove, Dir: filepath.Join(dir, "archive")},
+	}
+	// file, which is already in move directory, isn't replaced
+	assert.ErrorContains(t, u.AddFileToS3(&file), "already exists")
+	_, err := os.Stat(file.SourceFile)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(moved)
+	assert.NoError(t, err)
+	assert.Equal(t, "older", string(content))
+	assert.Empty(t, file.AfterAction)
+}
@@ -0,0 +1,102 @@
+package copy
+
+import (
+	"crypto/sha1" // nolint:gosec
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// newChecksumHash returns hash for S3 additional checksum algorithm
+func newChecksumHash(alg string) (hash.Hash, error) {
+	switch alg {
+	case s3.ChecksumAlgorithmSha256:
+		return sha256.New(), nil
+	case s3.ChecksumAlgorithmSha1:
+		return sha1.New(), nil // nolint:gosec
+	case s3.ChecksumAlgorithmCrc32:
+		return crc32.NewIEEE(), nil
+	case s3.ChecksumAlgorithmCrc32c:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	}
+	return nil, fmt.Errorf("unknown checksum algorithm '%s'", alg)
+}
+
+// checksumOf returns base64 encoded checksum of r, the way S3 expects it
+func checksumOf(alg string, r io.Reader) (string, error) {
+	h, err := newChecksumHash(alg)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksums holds value of one checksum, all others are nil
+type checksums struct {
+	CRC32, CRC32C, SHA1, SHA256 *string
+}
+
+func newChecksums(alg, value string) checksums {
+	var c checksums
+	switch alg {
+	case s3.ChecksumAlgorithmCrc32:
+		c.CRC32 = aws.String(value)
+	case s3.ChecksumAlgorithmCrc32c:
+		c.CRC32C = aws.String(value)
+	case s3.ChecksumAlgorithmSha1:
+		c.SHA1 = aws.String(value)
+	case s3.ChecksumAlgorithmSha256:
+		c.SHA256 = aws.String(value)
+	}
+	return c
+}
+
+// partChecksum returns checksum of part for alg or empty string if part has none
+func partChecksum(p *s3.Part, alg string) string {
+	switch alg {
+	case s3.ChecksumAlgorithmCrc32:
+		return aws.StringValue(p.ChecksumCRC32)
+	case s3.ChecksumAlgorithmCrc32c:
+		return aws.StringValue(p.ChecksumCRC32C)
+	case s3.ChecksumAlgorithmSha1:
+		return aws.StringValue(p.ChecksumSHA1)
+	case s3.ChecksumAlgorithmSha256:
+		return aws.StringValue(p.ChecksumSHA256)
+	}
+	return ""
+}
+
+// setInputChecksum sets checksum of whole file for single part upload.
+// SHA-256 which walker already calculated is reused, so S3 rejects file
+// if it was changed since then.
+func setInputChecksum(input *s3manager.UploadInput, f io.ReadSeeker, sha256Hex string) error {
+	alg := aws.StringValue(input.ChecksumAlgorithm)
+	var value string
+	if sum, err := hex.DecodeString(sha256Hex); alg == s3.ChecksumAlgorithmSha256 && err == nil && len(sum) == sha256.Size {
+		value = base64.StdEncoding.EncodeToString(sum)
+	} else {
+		if value, err = checksumOf(alg, f); err != nil {
+			return err
+		}
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	c := newChecksums(alg, value)
+	input.ChecksumCRC32 = c.CRC32
+	input.ChecksumCRC32C = c.CRC32C
+	input.ChecksumSHA1 = c.SHA1
+	input.ChecksumSHA256 = c.SHA256
+	return nil
+}
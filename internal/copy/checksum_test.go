@@ -0,0 +1,153 @@
+package copy
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+func TestChecksumOf(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Alg string
+		Sum string
+	}{
+		{Alg: s3.ChecksumAlgorithmCrc32, Sum: "y/Q5Jg=="},
+		{Alg: s3.ChecksumAlgorithmCrc32c, Sum: "4waSgw=="},
+		{Alg: s3.ChecksumAlgorithmSha1, Sum: "98O8HYCOBHMq32eZZczDTKeuNEE="},
+		{Alg: s3.ChecksumAlgorithmSha256, Sum: "FeKw08M4keuw8e9gnsQZQgwg4yDOlMZfvIwzEkSOsiU="},
+	}
+	for i, c := range cases {
+		sum, err := checksumOf(c.Alg, strings.NewReader("123456789"))
+		assert.NoError(t, err)
+		assert.Equal(t, c.Sum, sum, fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+	_, err := checksumOf("MD5", strings.NewReader("123456789"))
+	assert.Error(t, err)
+}
+
+func TestSetInputChecksum(t *testing.T) {
+	t.Parallel()
+
+	// SHA-256 calculated by walker is reused
+	input := &s3manager.UploadInput{ChecksumAlgorithm: aws.String(s3.ChecksumAlgorithmSha256)}
+	assert.NoError(t, setInputChecksum(input, strings.NewReader("not used"),
+		"15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225"))
+	assert.Equal(t, "FeKw08M4keuw8e9gnsQZQgwg4yDOlMZfvIwzEkSOsiU=", aws.StringValue(input.ChecksumSHA256))
+
+	// others are calculated and reader is rewound
+	input = &s3manager.UploadInput{ChecksumAlgorithm: aws.String(s3.ChecksumAlgorithmCrc32c)}
+	r := strings.NewReader("123456789")
+	assert.NoError(t, setInputChecksum(input, r, ""))
+	assert.Equal(t, "4waSgw==", aws.StringValue(input.ChecksumCRC32C))
+	assert.Nil(t, input.ChecksumSHA256)
+	rest, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "123456789", string(rest))
+}
+
+func TestAddFileToS3WithChecksum(t *testing.T) {
+	f, size := createTestFile(t)
+	client := &mockS3{}
+	u := Uploader{
+		Client:            &flakyS3Manager{},
+		S3:                client,
+		S3Bucket:          "bucket",
+		PartSize:          testPartSize,
+		ChecksumAlgorithm: s3.ChecksumAlgorithmCrc32c,
+	}
+	file := walker.SrcDest{
+		SourceFile:   f.Name(),
+		SourceSha256: "abc",
+		DstObject:    "file",
+	}
+	assert.NoError(t, u.AddFileToS3(&file))
+	assert.Len(t, client.Created, 1)
+	assert.Equal(t, s3.ChecksumAlgorithmCrc32c, aws.StringValue(client.Created[0].ChecksumAlgorithm))
+	assert.Equal(t, "abc", aws.StringValue(client.Created[0].Metadata[MetaSHA256]))
+	assert.Len(t, client.SentParts, 4)
+	assert.Len(t, client.Completed, 4)
+	sort.Slice(client.SentParts, func(i, j int) bool {
+		return *client.SentParts[i].PartNumber < *client.SentParts[j].PartNumber
+	})
+	for i, p := range client.Completed {
+		offset, length := partLayout(int64(i+1), size, testPartSize)
+		sum, err := checksumOf(s3.ChecksumAlgorithmCrc32c, io.NewSectionReader(f, offset, length))
+		assert.NoError(t, err)
+		assert.Equal(t, sum, aws.StringValue(p.ChecksumCRC32C))
+		assert.Equal(t, sum, aws.StringValue(client.SentParts[i].ChecksumCRC32C))
+	}
+}
+
+func TestResumeWithChecksum(t *testing.T) {
+	f, size := createTestFile(t)
+	withChecksum := func(n int64, sum string) *s3.Part {
+		p := partOf(t, f, n, size, "")
+		if sum == "" {
+			offset, length := partLayout(n, size, testPartSize)
+			var err error
+			sum, err = checksumOf(s3.ChecksumAlgorithmSha256, io.NewSectionReader(f, offset, length))
+			assert.NoError(t, err)
+		}
+		p.ChecksumSHA256 = aws.String(sum)
+		return p
+	}
+	good := withChecksum(1, "")
+	client := &mockS3{
+		Uploads: []*s3.MultipartUpload{{
+			Key:               aws.String("file"),
+			UploadId:          aws.String("id"),
+			Initiated:         aws.Time(time.Now()),
+			ChecksumAlgorithm: aws.String(s3.ChecksumAlgorithmSha256),
+		}},
+		Parts: []*s3.Part{good, withChecksum(2, "")},
+	}
+	u := Uploader{S3: client}
+	input := &s3manager.UploadInput{
+		Bucket:            aws.String("bucket"),
+		Key:               aws.String("file"),
+		ChecksumAlgorithm: aws.String(s3.ChecksumAlgorithmSha256),
+		// ETag isn't MD5 for SSE-C, so only checksum could be trusted
+		SSECustomerKey: aws.String("key"),
+	}
+	resumedPartSize, err := u.tryResume(f, size, input, testPartSize)
+	assert.NoError(t, err)
+	assert.Equal(t, testPartSize, resumedPartSize)
+	sort.Slice(client.Sent, func(i, j int) bool { return client.Sent[i] < client.Sent[j] })
+	assert.Equal(t, []int64{3, 4}, client.Sent)
+	assert.Equal(t, aws.StringValue(good.ChecksumSHA256), aws.StringValue(client.Completed[0].ChecksumSHA256))
+	assert.Empty(t, client.Aborted)
+
+	// part with other checksum means file changed, so upload starts again
+	client.Parts = []*s3.Part{good, withChecksum(2, "bad")}
+	client.Sent = nil
+	resumedPartSize, err = u.tryResume(f, size, input, testPartSize)
+	assert.NoError(t, err)
+	assert.Zero(t, resumedPartSize)
+	assert.Empty(t, client.Sent)
+	assert.Equal(t, []string{"id"}, client.Aborted)
+
+	// upload without checksums can't be completed with them
+	client.Uploads[0].ChecksumAlgorithm = nil
+	client.Parts = []*s3.Part{good}
+	client.Aborted = nil
+	resumedPartSize, err = u.tryResume(f, size, &s3manager.UploadInput{
+		Bucket:            aws.String("bucket"),
+		Key:               aws.String("file"),
+		ChecksumAlgorithm: aws.String(s3.ChecksumAlgorithmSha256),
+	}, testPartSize)
+	assert.NoError(t, err)
+	assert.Zero(t, resumedPartSize)
+	assert.Equal(t, []string{"id"}, client.Aborted)
+}
@@ -0,0 +1,106 @@
+package copy
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/sarunask/s3-copy/internal/throttle"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// Compression algorithms
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+)
+
+// GzipSuffix is added to keys of compressed objects, if Compression.Suffix is set
+const GzipSuffix = ".gz"
+
+// Compression describes which files are compressed with gzip before upload
+type Compression struct {
+	// Level is gzip compression level
+	Level int
+	// Include selects files to compress by path, nil means all files
+	Include *regexp.Regexp
+	// Exclude selects files not to compress by path, nil means none
+	Exclude *regexp.Regexp
+	// Suffix adds GzipSuffix to keys of compressed objects
+	Suffix bool
+}
+
+// Compresses tells if file on path is compressed before upload
+func (u *Uploader) Compresses(path string) bool {
+	c := u.Compression
+	if c == nil {
+		return false
+	}
+	if c.Include != nil && !c.Include.MatchString(path) {
+		return false
+	}
+	return c.Exclude == nil || !c.Exclude.MatchString(path)
+}
+
+// ObjectKey returns key of object uploaded from file
+func (u *Uploader) ObjectKey(file *walker.SrcDest) string {
+	if u.Compresses(file.SourceFile) && u.Compression.Suffix {
+		return file.DstObject + GzipSuffix
+	}
+	return file.DstObject
+}
+
+// countingHash counts and hashes everything written to it
+type countingHash struct {
+	hash.Hash
+	size int64
+}
+
+func (c *countingHash) Write(p []byte) (int, error) {
+	c.size += int64(len(p))
+	return c.Hash.Write(p)
+}
+
+// uploadCompressed uploads f compressed with gzip as it's read and records
+// size and SHA-256 of compressed content in file. Compressed stream can't
+// be read again, so failed upload is aborted and never resumed.
+func (u *Uploader) uploadCompressed(f *os.File, file *walker.SrcDest, input *s3manager.UploadInput, partSize int64) error {
+	zw, err := gzip.NewWriterLevel(nil, u.Compression.Level)
+	if err != nil {
+		return fmt.Errorf("can't compress %v: %w", f.Name(), err)
+	}
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		zw.Reset(pw)
+		_, err := io.Copy(zw, f)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	sum := &countingHash{Hash: sha256.New()}
+	input.Body = throttle.NewReader(io.TeeReader(pr, sum), u.Bandwidth)
+	input.ContentEncoding = aws.String(CompressGzip)
+	result, err := u.Client.Upload(input, func(up *s3manager.Uploader) {
+		up.PartSize = partSize
+		up.Concurrency = u.partConcurrency()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload file %v: %w", f.Name(), err)
+	}
+	file.CompressedSha256 = fmt.Sprintf("%x", sum.Sum(nil))
+	file.CompressedSize = uint64(sum.size)
+	log.Infof("successfuly uploaded %v compressed from %d to %d bytes to %v",
+		f.Name(), file.SourceSize, sum.size, result.Location)
+	return nil
+}
@@ -0,0 +1,89 @@
+package copy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+func TestCompresses(t *testing.T) {
+	t.Parallel()
+
+	u := Uploader{}
+	assert.False(t, u.Compresses("data.csv"))
+	cases := []struct {
+		Compression Compression
+		Path        string
+		Compresses  bool
+	}{
+		{Compression: Compression{}, Path: "data.csv", Compresses: true},
+		{Compression: Compression{Include: regexp.MustCompile(`\.csv$`)}, Path: "data.csv", Compresses: true},
+		{Compression: Compression{Include: regexp.MustCompile(`\.csv$`)}, Path: "data.bin", Compresses: false},
+		{Compression: Compression{Exclude: regexp.MustCompile(`\.(gz|zip)$`)}, Path: "data.gz", Compresses: false},
+		{Compression: Compression{Exclude: regexp.MustCompile(`\.(gz|zip)$`)}, Path: "data.log", Compresses: true},
+	}
+	for i, c := range cases {
+		compression := c.Compression
+		u := Uploader{Compression: &compression}
+		assert.Equal(t, c.Compresses, u.Compresses(c.Path), fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestCompressedUpload(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte(strings.Repeat("id,name,value\n1,some,42\n", 10000))
+	path := filepath.Join(dir, "data.csv")
+	assert.NoError(t, os.WriteFile(path, content, 0600))
+	sum := fmt.Sprintf("%x", sha256.Sum256(content))
+
+	client := &mockS3{Objects: map[string]*s3.HeadObjectOutput{}}
+	store := &bucket{S3: client}
+	u := Uploader{
+		Client:      store,
+		S3:          client,
+		S3Bucket:    "bucket",
+		Verify:      true,
+		Compression: &Compression{Level: gzip.BestCompression, Suffix: true},
+	}
+	file := walker.SrcDest{
+		SourceFile:   path,
+		SourceSha256: sum,
+		SourceSize:   uint64(len(content)),
+		DstObject:    "dir/data.csv",
+	}
+	assert.NoError(t, u.AddFileToS3(&file))
+	assert.Equal(t, walker.StatusUploaded, file.Status)
+	assert.Equal(t, "dir/data.csv.gz", file.DstObject)
+	assert.Equal(t, "dir/data.csv.gz", aws.StringValue(store.Input.Key))
+	assert.Equal(t, "gzip", aws.StringValue(store.Input.ContentEncoding))
+	assert.Equal(t, sum, aws.StringValue(store.Input.Metadata[MetaSHA256]))
+	assert.Equal(t, uint64(len(store.Content)), file.CompressedSize)
+	assert.Less(t, file.CompressedSize, file.SourceSize/10)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(store.Content)), file.CompressedSha256)
+	zr, err := gzip.NewReader(bytes.NewReader(store.Content))
+	assert.NoError(t, err)
+	uncompressed, err := io.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(content, uncompressed))
+
+	// already uploaded file is skipped by SHA-256 even in size mode
+	u.SkipExisting = SkipSize
+	file.DstObject = "dir/data.csv"
+	file.Status = ""
+	assert.NoError(t, u.AddFileToS3(&file))
+	assert.Equal(t, walker.StatusSkipped, file.Status)
+	assert.Equal(t, "dir/data.csv.gz", file.DstObject)
+}
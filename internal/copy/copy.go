@@ -1,34 +1,90 @@
 package copy
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 
+	"github.com/sarunask/s3-copy/internal/envelope"
+	"github.com/sarunask/s3-copy/internal/retry"
+	"github.com/sarunask/s3-copy/internal/ssec"
+	"github.com/sarunask/s3-copy/internal/throttle"
 	"github.com/sarunask/s3-copy/internal/walker"
 )
 
 // Uploader provides class to upload files to S3
 type Uploader struct {
-	Client    s3manageriface.UploaderAPI
+	Client s3manageriface.UploaderAPI
+	// S3 is used to find and resume interrupted multipart uploads,
+	// if it's nil every upload starts from the beginning
+	S3        s3iface.S3API
 	S3Bucket  string
 	S3SSEC    string
 	S3SSECKey string
+	Retry     retry.Policy
+	// PartSize of multipart uploads, zero means it's chosen by file size
+	PartSize int64
+	// Bandwidth limits how fast files are read for all uploads, nil means no limit
+	Bandwidth *throttle.Limiter
+	// ChecksumAlgorithm is S3 additional checksum algorithm, e.g. SHA256 or
+	// CRC32C. With empty algorithm no additional checksum is sent.
+	ChecksumAlgorithm string
+	// Verify checks every uploaded object against local file,
+	// it requires S3 to be set
+	Verify bool
+	// SSE is server-side encryption with S3 or KMS managed keys, it can't be used with SSE-C
+	SSE SSE
+	// Object has headers set on every uploaded object
+	Object ObjectOptions
+	// After tells what to do with local files after they are uploaded and verified
+	After AfterUpload
+	// SkipExisting tells when files already uploaded to S3 are skipped,
+	// it requires S3 to be set
+	SkipExisting SkipMode
+	// PartConcurrency is how many parts of single file are uploaded at once,
+	// zero means s3manager.DefaultUploadConcurrency
+	PartConcurrency int
+	// Encryption encrypts files on client side before upload, nil means
+	// they are uploaded as they are
+	Encryption *envelope.Key
+	// Compression compresses files before upload, nil means they are
+	// uploaded as they are
+	Compression *Compression
+}
+
+// ObjectSize returns size of object uploaded from file of size bytes
+func (u *Uploader) ObjectSize(size int64) int64 {
+	if u.Encryption != nil {
+		return envelope.EncryptedSize(size)
+	}
+	return size
+}
+
+func (u *Uploader) partConcurrency() int {
+	if u.PartConcurrency < 1 {
+		return s3manager.DefaultUploadConcurrency
+	}
+	return u.PartConcurrency
 }
 
 // AddFileToS3 will upload a single file to S3, it will require a pre-built aws session
 // and will set file info like content type and encryption on the uploaded file.
-func (u *Uploader) AddFileToS3(file walker.SrcDest) error {
-	// Create an uploader with the session and default options
+// Upload is retried according to Retry policy and attempts count with class
+// of the last error are recorded in file.
+func (u *Uploader) AddFileToS3(file *walker.SrcDest) error {
 	info, err := os.Stat(file.SourceFile)
 	if err != nil {
+		file.ErrorClass = string(retry.ClassFatal)
 		return fmt.Errorf("could get stats for %v: %w", file.SourceFile, err)
 	}
 	if info.IsDir() {
@@ -36,30 +92,243 @@ func (u *Uploader) AddFileToS3(file walker.SrcDest) error {
 		log.Debugf("Skiping %s as it's directory", file.SourceFile)
 		return nil
 	}
-	// It's not directory we upload, so read content
-	f, err := os.Open(file.SourceFile)
+	if err := u.validateRow(file.Options); err != nil {
+		file.ErrorClass = string(retry.ClassFatal)
+		return fmt.Errorf("bad options of %v: %w", file.SourceFile, err)
+	}
+	// file is moved after upload by its original key, but reported by key of object
+	defer func() {
+		file.DstObject = u.ObjectKey(file)
+	}()
+	var partSize int64
+	attempts, class, err := u.Retry.Do(func(attempt int) error {
+		if attempt > 1 {
+			log.Infof("attempt %d to upload %v", attempt, file.SourceFile)
+		}
+		partSize, err = u.upload(file, info.Size())
+		return err
+	})
+	file.Attempts = attempts
+	file.ErrorClass = string(class)
 	if err != nil {
-		return fmt.Errorf("failed to open file %v: %w", file.SourceFile, err)
+		return err
 	}
-	defer f.Close()
+	file.KeyMD5 = ssec.Fingerprint(u.sseCKey(file))
+	if len(file.Status) == 0 {
+		file.Status = walker.StatusUploaded
+	}
+	if file.Status != walker.StatusUploaded {
+		return nil
+	}
+	if u.Verify {
+		_, class, err = u.Retry.Do(func(int) error {
+			return u.verify(file, info.Size(), partSize)
+		})
+		if errors.Is(err, errMismatch) {
+			class = retry.ClassMismatch
+		}
+		file.ErrorClass = string(class)
+		if err != nil {
+			return err
+		}
+	}
+	if err := u.afterUpload(file); err != nil {
+		file.ErrorClass = string(retry.ClassFatal)
+		return err
+	}
+	return nil
+}
 
+// sseCKey returns SSE-C key of file, key or server-side encryption of CSV
+// row overrides global key
+func (u *Uploader) sseCKey(file *walker.SrcDest) string {
+	if file.Options != nil && len(file.Options.SSECKey) != 0 {
+		return file.Options.SSECKey
+	}
+	if file.Options != nil && len(file.Options.SSE) != 0 {
+		return ""
+	}
+	return u.S3SSECKey
+}
+
+// uploadInput returns upload parameters for file without body
+func (u *Uploader) uploadInput(file *walker.SrcDest) *s3manager.UploadInput {
 	input := &s3manager.UploadInput{
 		Bucket: aws.String(u.S3Bucket),
-		Key:    aws.String(filepath.ToSlash(file.DstObject)),
-		Body:   f,
+		Key:    aws.String(filepath.ToSlash(u.ObjectKey(file))),
+	}
+	if len(file.SourceSha256) != 0 {
+		input.Metadata = map[string]*string{
+			MetaSHA256: aws.String(file.SourceSha256),
+		}
 	}
-	if len(u.S3SSECKey) != 0 {
+	if sseCKey := u.sseCKey(file); len(sseCKey) != 0 {
 		input.SSECustomerAlgorithm = aws.String(u.S3SSEC)
-		input.SSECustomerKey = aws.String(u.S3SSECKey)
+		input.SSECustomerKey = aws.String(sseCKey)
+	}
+	if input.SSECustomerKey == nil {
+		u.SSE.withRow(file.Options).apply(input)
+	}
+	if len(u.ChecksumAlgorithm) != 0 {
+		input.ChecksumAlgorithm = aws.String(u.ChecksumAlgorithm)
+	}
+	opts := u.Object.withRow(file.Options)
+	opts.apply(input)
+	return input
+}
+
+// upload makes single attempt to upload file and returns part size it used
+func (u *Uploader) upload(file *walker.SrcDest, size int64) (int64, error) {
+	// It's not directory we upload, so read content
+	f, err := os.Open(file.SourceFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file %v: %w", file.SourceFile, err)
+	}
+	defer f.Close()
+
+	input := u.uploadInput(file)
+	opts := u.Object.withRow(file.Options)
+	if u.Encryption != nil && len(opts.ContentType) == 0 {
+		// encrypted content has nothing to detect and plaintext type shouldn't leak
+		opts.ContentType = envelope.ContentType
+	}
+	input.ContentType = aws.String(opts.contentType(file.SourceFile, f))
+	input.Body = throttle.NewFile(f, u.Bandwidth)
+	unchanged, err := u.unchanged(file, size, input)
+	if err != nil {
+		return 0, err
+	}
+	if unchanged {
+		log.Infof("skipping %v as %v is already in S3", file.SourceFile, *input.Key)
+		file.Status = walker.StatusSkipped
+		return 0, nil
+	}
+	partSize, err := PartSize(u.ObjectSize(size), u.PartSize)
+	if err != nil {
+		return 0, fmt.Errorf("can't upload %v: %w", file.SourceFile, err)
+	}
+	if u.Encryption != nil {
+		return partSize, u.uploadEncrypted(f, input, partSize)
+	}
+	if u.Compresses(file.SourceFile) {
+		return partSize, u.uploadCompressed(f, file, input, partSize)
+	}
+	// Only files bigger than part size are uploaded in parts
+	if u.S3 != nil && size > partSize {
+		resumedPartSize, err := u.tryResume(f, size, input, partSize)
+		if err != nil {
+			return 0, fmt.Errorf("failed to resume upload of %v: %w", file.SourceFile, err)
+		}
+		if resumedPartSize != 0 {
+			log.Infof("successfuly resumed upload of %v to %v", file.SourceFile, *input.Key)
+			return resumedPartSize, nil
+		}
+	}
+	if input.ChecksumAlgorithm != nil {
+		if size > partSize && u.S3 != nil {
+			// s3manager doesn't send checksums of parts, so we upload them ourselves
+			if err := u.uploadMultipart(f, size, input, partSize); err != nil {
+				return 0, fmt.Errorf("failed to upload file %v: %w", file.SourceFile, err)
+			}
+			log.Infof("successfuly uploaded %v to %v", file.SourceFile, *input.Key)
+			return partSize, nil
+		}
+		if err := setInputChecksum(input, f, file.SourceSha256); err != nil {
+			return 0, fmt.Errorf("can't calculate checksum of %v: %w", file.SourceFile, err)
+		}
 	}
 	// Upload the file to S3.
-	result, err := u.Client.Upload(input, func(u *s3manager.Uploader) {
-		u.PartSize = 10 * 1024 * 1024 // 10MB part size
-		u.LeavePartsOnError = true    // Don't delete the parts if the upload fails.
+	result, err := u.Client.Upload(input, func(up *s3manager.Uploader) {
+		up.PartSize = partSize
+		up.Concurrency = u.partConcurrency()
+		up.LeavePartsOnError = true // Keep the parts if the upload fails, so it could be resumed.
 	})
 	if err != nil {
-		return fmt.Errorf("failed to upload file %v: %w", file.SourceFile, err)
+		return 0, fmt.Errorf("failed to upload file %v: %w", file.SourceFile, err)
 	}
 	log.Infof("successfuly uploaded %v to %v", file.SourceFile, result.Location)
+	return partSize, nil
+}
+
+// UploadStream uploads body of about size bytes to key with encryption and
+// object options of all files, but without per-file metadata. Stream can't
+// be read again, so it isn't retried or resumed.
+func (u *Uploader) UploadStream(key, contentType string, body io.Reader, size int64) error {
+	input := u.uploadInput(&walker.SrcDest{DstObject: key})
+	input.Key = aws.String(key)
+	input.ContentType = aws.String(contentType)
+	// checksums of parts are calculated before upload
+	input.ChecksumAlgorithm = nil
+	input.Body = throttle.NewReader(body, u.Bandwidth)
+	partSize, err := PartSize(size, u.PartSize)
+	if err != nil {
+		return fmt.Errorf("can't upload %v: %w", key, err)
+	}
+	result, err := u.Client.Upload(input, func(up *s3manager.Uploader) {
+		up.PartSize = partSize
+		up.Concurrency = u.partConcurrency()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %v: %w", key, err)
+	}
+	log.Infof("successfuly uploaded %v", result.Location)
+	return nil
+}
+
+// uploadEncrypted uploads f encrypted with new data key. Every attempt has
+// its own data key, so parts of failed upload are aborted and never resumed.
+func (u *Uploader) uploadEncrypted(f *os.File, input *s3manager.UploadInput, partSize int64) error {
+	body, metadata, err := u.Encryption.Encrypt(throttle.NewReader(f, u.Bandwidth))
+	if err != nil {
+		return fmt.Errorf("can't encrypt %v: %w", f.Name(), err)
+	}
+	if input.Metadata == nil {
+		input.Metadata = make(map[string]*string, len(metadata))
+	}
+	for k, v := range metadata {
+		input.Metadata[k] = aws.String(v)
+	}
+	input.Body = body
+	result, err := u.Client.Upload(input, func(up *s3manager.Uploader) {
+		up.PartSize = partSize
+		up.Concurrency = u.partConcurrency()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload file %v: %w", f.Name(), err)
+	}
+	log.Infof("successfuly uploaded encrypted %v to %v", f.Name(), result.Location)
 	return nil
 }
+
+// tryResume will finish interrupted multipart upload of f if there is one.
+// It returns part size of resumed upload or zero if there was nothing to
+// resume and file should be uploaded from the beginning. Object of resumed
+// upload, which got other metadata or headers, is uploaded again too.
+func (u *Uploader) tryResume(f *os.File, size int64, input *s3manager.UploadInput, partSize int64) (int64, error) {
+	upload, err := u.findUpload(*input.Bucket, *input.Key)
+	if err != nil || upload == nil {
+		return 0, err
+	}
+	partSize, err = u.resume(f, size, input, upload, partSize)
+	if errors.Is(err, errNotResumable) {
+		log.Warnf("can't resume upload %s of %s, starting from the beginning: %v",
+			aws.StringValue(upload.UploadId), f.Name(), err)
+		u.abortUpload(input, upload)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	// object, which can't be checked, could be stale too
+	differs, err := u.objectDiffers(input)
+	if err != nil {
+		log.Warnf("can't check resumed object, uploading %s from the beginning: %v", f.Name(), err)
+		return 0, nil
+	}
+	if len(differs) != 0 {
+		log.Warnf("resumed object %s has other %s than %s, uploading it from the beginning",
+			aws.StringValue(input.Key), differs, f.Name())
+		return 0, nil
+	}
+	return partSize, nil
+}
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"path"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/sarunask/s3-copy/internal/retry"
 	"github.com/sarunask/s3-copy/internal/walker"
 )
 
@@ -40,7 +43,7 @@ func TestAddFileToS3(t *testing.T) {
 			S3SSEC:    "AES256",
 			S3SSECKey: fmt.Sprintf("czn8qrbUsT/5y5Hr2i93ImWmIQLCZ1%0d", i),
 		}
-		err := u.AddFileToS3(walker.SrcDest{
+		err := u.AddFileToS3(&walker.SrcDest{
 			SourceFile: "./copy.go",
 			DstObject:  "./copy.go",
 		})
@@ -49,3 +52,77 @@ func TestAddFileToS3(t *testing.T) {
 		}
 	}
 }
+
+type flakyS3Manager struct {
+	s3manageriface.UploaderAPI
+	Errs   []error
+	Calls  int
+	Inputs []*s3manager.UploadInput
+}
+
+func (m *flakyS3Manager) Upload(inp *s3manager.UploadInput, _ ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	m.Calls++
+	m.Inputs = append(m.Inputs, inp)
+	if m.Calls <= len(m.Errs) {
+		return nil, m.Errs[m.Calls-1]
+	}
+	return &s3manager.UploadOutput{Location: *inp.Key}, nil
+}
+
+func TestAddFileToS3Retries(t *testing.T) {
+	cases := []struct {
+		Errs       []error
+		Attempts   int
+		ErrorClass retry.Class
+		Fail       bool
+	}{
+		{
+			Errs:       []error{awserr.New("SlowDown", "Please reduce your request rate.", nil)},
+			Attempts:   2,
+			ErrorClass: retry.ClassNone,
+		},
+		{
+			Errs:       []error{awserr.New("AccessDenied", "Access Denied", nil)},
+			Attempts:   1,
+			ErrorClass: retry.ClassFatal,
+			Fail:       true,
+		},
+		{
+			Errs: []error{
+				awserr.New("RequestTimeout", "", nil),
+				awserr.New("RequestTimeout", "", nil),
+				awserr.New("RequestTimeout", "", nil),
+			},
+			Attempts:   3,
+			ErrorClass: retry.ClassTransient,
+			Fail:       true,
+		},
+	}
+
+	for i, c := range cases {
+		client := &flakyS3Manager{Errs: c.Errs}
+		u := Uploader{
+			Client:   client,
+			S3Bucket: "mockS3Bucket",
+			Retry: retry.Policy{
+				MaxAttempts: 3,
+				BaseDelay:   time.Millisecond,
+				MaxDelay:    time.Millisecond,
+			},
+		}
+		file := walker.SrcDest{
+			SourceFile: "./copy.go",
+			DstObject:  "./copy.go",
+		}
+		err := u.AddFileToS3(&file)
+		if c.Fail != (err != nil) {
+			t.Fatalf("%d, unexpected error %v", i, err)
+		}
+		if file.Attempts != c.Attempts || client.Calls != c.Attempts {
+			t.Fatalf("%d, expected %d attempts, got %d and %d calls", i, c.Attempts, file.Attempts, client.Calls)
+		}
+		if file.ErrorClass != string(c.ErrorClass) {
+			t.Fatalf("%d, expected %q error class, got %q", i, c.ErrorClass, file.ErrorClass)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package copy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+
+	"github.com/sarunask/s3-copy/internal/envelope"
+	"github.com/sarunask/s3-copy/internal/listing"
+	"github.com/sarunask/s3-copy/internal/retry"
+	"github.com/sarunask/s3-copy/internal/ssec"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// Downloader provides class to download objects from S3 to local files
+type Downloader struct {
+	Client    s3manageriface.DownloaderAPI
+	S3Bucket  string
+	S3SSEC    string
+	S3SSECKey string
+	Retry     retry.Policy
+	// PartSize of ranged GETs, zero means s3manager.DefaultDownloadPartSize
+	PartSize int64
+	// PartConcurrency is how many parts of single object are downloaded at once,
+	// zero means s3manager.DefaultDownloadConcurrency
+	PartConcurrency int
+	// Decryption decrypts objects encrypted on client side, nil means they
+	// are written as they are. S3 is required to read their metadata.
+	Decryption *envelope.Key
+	S3         s3iface.S3API
+	// Dir is directory, which local files should be inside of. Keys of
+	// objects and CSV records could point anywhere with "..", so files
+	// outside of it are refused. Empty Dir allows any path.
+	Dir string
+}
+
+// GetFileFromS3 will download object file.SourceFile to local path
+// file.DstObject. Object is written to temporary file in the same directory
+// first, so interrupted download never leaves partial file in its place.
+func (d *Downloader) GetFileFromS3(file *walker.SrcDest) error {
+	if len(d.Dir) != 0 {
+		if err := listing.Contained(d.Dir, file.DstObject); err != nil {
+			file.ErrorClass = string(retry.ClassFatal)
+			return fmt.Errorf("refusing to write %v: %w", file.SourceFile, err)
+		}
+	}
+	dir := filepath.Dir(file.DstObject)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		file.ErrorClass = string(retry.ClassFatal)
+		return fmt.Errorf("can't create directory %v: %w", dir, err)
+	}
+	attempts, class, err := d.Retry.Do(func(attempt int) error {
+		if attempt > 1 {
+			log.Infof("attempt %d to download %v", attempt, file.SourceFile)
+		}
+		return d.download(file)
+	})
+	file.Attempts = attempts
+	file.ErrorClass = string(class)
+	if err == nil {
+		file.Status = walker.StatusDownloaded
+		file.KeyMD5 = ssec.Fingerprint(d.S3SSECKey)
+	}
+	return err
+}
+
+// download makes single attempt to download object
+func (d *Downloader) download(file *walker.SrcDest) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file.DstObject), "."+filepath.Base(file.DstObject)+".*")
+	if err != nil {
+		return fmt.Errorf("can't create temporary file for %v: %w", file.DstObject, err)
+	}
+	defer func() {
+		tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(d.S3Bucket),
+		Key:    aws.String(file.SourceFile),
+	}
+	if len(d.S3SSECKey) != 0 {
+		input.SSECustomerAlgorithm = aws.String(d.S3SSEC)
+		input.SSECustomerKey = aws.String(d.S3SSECKey)
+	}
+	var metadata map[string]*string
+	if d.Decryption != nil {
+		head, err := d.S3.HeadObject(&s3.HeadObjectInput{
+			Bucket:               input.Bucket,
+			Key:                  input.Key,
+			SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+			SSECustomerKey:       input.SSECustomerKey,
+		})
+		if err != nil {
+			return fmt.Errorf("can't get %v: %w", file.SourceFile, err)
+		}
+		if !envelope.IsEncrypted(head.Metadata) {
+			return fmt.Errorf("%v isn't encrypted on client side", file.SourceFile)
+		}
+		metadata = head.Metadata
+		// object shouldn't be replaced between HEAD and GET
+		input.IfMatch = head.ETag
+	}
+	size, err := d.Client.Download(tmp, input, func(down *s3manager.Downloader) {
+		if d.PartSize > 0 {
+			down.PartSize = d.PartSize
+		}
+		if d.PartConcurrency > 0 {
+			down.Concurrency = d.PartConcurrency
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download %v: %w", file.SourceFile, err)
+	}
+	h := sha256.New()
+	out := tmp
+	if d.Decryption != nil {
+		out, err = os.CreateTemp(filepath.Dir(file.DstObject), "."+filepath.Base(file.DstObject)+".*")
+		if err != nil {
+			return fmt.Errorf("can't create temporary file for %v: %w", file.DstObject, err)
+		}
+		defer func() {
+			out.Close()
+			_ = os.Remove(out.Name())
+		}()
+		size, err = d.Decryption.Decrypt(io.MultiWriter(out, h), io.NewSectionReader(tmp, 0, size), metadata)
+		if err != nil {
+			return fmt.Errorf("can't decrypt %v: %w", file.SourceFile, err)
+		}
+	} else if _, err := io.Copy(h, io.NewSectionReader(tmp, 0, size)); err != nil {
+		return fmt.Errorf("can't calculate sum for %v: %w", tmp.Name(), err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("can't write %v: %w", out.Name(), err)
+	}
+	if err := os.Rename(out.Name(), file.DstObject); err != nil {
+		return fmt.Errorf("can't move %v to %v: %w", out.Name(), file.DstObject, err)
+	}
+	file.SourceSha256 = fmt.Sprintf("%x", h.Sum(nil))
+	file.SourceSize = uint64(size)
+	log.Infof("successfuly downloaded %v to %v", file.SourceFile, file.DstObject)
+	return nil
+}
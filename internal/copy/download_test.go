@@ -0,0 +1,109 @@
+package copy
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/listing"
+	"github.com/sarunask/s3-copy/internal/retry"
+	"github.com/sarunask/s3-copy/internal/ssec"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+type mockDownloader struct {
+	s3manageriface.DownloaderAPI
+	Errs   []error
+	Calls  int
+	Inputs []*s3.GetObjectInput
+}
+
+func (m *mockDownloader) Download(w io.WriterAt, inp *s3.GetObjectInput, _ ...func(*s3manager.Downloader)) (int64, error) {
+	m.Calls++
+	m.Inputs = append(m.Inputs, inp)
+	if m.Calls <= len(m.Errs) {
+		// half written object should never be seen in destination
+		_, _ = w.WriteAt([]byte("partial"), 0)
+		return 0, m.Errs[m.Calls-1]
+	}
+	n, err := w.WriteAt([]byte("123456789"), 0)
+	return int64(n), err
+}
+
+func TestGetFileFromS3(t *testing.T) {
+	dir := t.TempDir()
+	client := &mockDownloader{Errs: []error{awserr.New("RequestTimeout", "timeout", nil)}}
+	d := Downloader{
+		Client:    client,
+		S3Bucket:  "bucket",
+		S3SSEC:    "AES256",
+		S3SSECKey: "key",
+		Retry: retry.Policy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+			MaxDelay:    time.Millisecond,
+		},
+	}
+	file := walker.SrcDest{
+		SourceFile: "dir/file",
+		DstObject:  filepath.Join(dir, "sub", "file"),
+	}
+	assert.NoError(t, d.GetFileFromS3(&file))
+	content, err := os.ReadFile(file.DstObject)
+	assert.NoError(t, err)
+	assert.Equal(t, "123456789", string(content))
+	assert.Equal(t, "15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225", file.SourceSha256)
+	assert.Equal(t, uint64(9), file.SourceSize)
+	assert.Equal(t, 2, file.Attempts)
+	assert.Equal(t, walker.StatusDownloaded, file.Status)
+	assert.Equal(t, "key", aws.StringValue(client.Inputs[0].SSECustomerKey))
+	assert.Equal(t, ssec.Fingerprint("key"), file.KeyMD5)
+	// temporary files are removed
+	entries, err := os.ReadDir(filepath.Join(dir, "sub"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestGetFileFromS3Fails(t *testing.T) {
+	dir := t.TempDir()
+	client := &mockDownloader{Errs: []error{awserr.New("AccessDenied", "Access Denied", nil)}}
+	d := Downloader{Client: client, S3Bucket: "bucket"}
+	file := walker.SrcDest{
+		SourceFile: "file",
+		DstObject:  filepath.Join(dir, "file"),
+	}
+	assert.Error(t, d.GetFileFromS3(&file))
+	assert.Equal(t, string(retry.ClassFatal), file.ErrorClass)
+	assert.Empty(t, file.Status)
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestGetFileFromS3Outside(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "restore")
+	client := &mockDownloader{}
+	d := Downloader{Client: client, S3Bucket: "bucket", Dir: dir}
+	key := "data/../../escaped"
+	file := walker.SrcDest{
+		SourceFile: key,
+		DstObject:  listing.LocalPath(dir, "data/", key),
+	}
+	assert.ErrorContains(t, d.GetFileFromS3(&file), "outside of")
+	assert.Equal(t, string(retry.ClassFatal), file.ErrorClass)
+	assert.Equal(t, 0, client.Calls)
+	_, err := os.Stat(filepath.Join(root, "escaped"))
+	assert.True(t, os.IsNotExist(err))
+}
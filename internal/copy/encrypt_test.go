@@ -0,0 +1,105 @@
+package copy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/envelope"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// bucket stores uploaded content and serves it to downloads, HEAD
+// responses of uploaded objects are added to S3
+type bucket struct {
+	s3manageriface.UploaderAPI
+	s3manageriface.DownloaderAPI
+	S3      *mockS3
+	Content []byte
+	Input   *s3manager.UploadInput
+}
+
+func (b *bucket) Upload(inp *s3manager.UploadInput, _ ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	content, err := io.ReadAll(inp.Body)
+	if err != nil {
+		return nil, err
+	}
+	b.Content = content
+	b.Input = inp
+	b.S3.Objects[*inp.Key] = &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(content))),
+		ETag:          aws.String(`"etag"`),
+		Metadata:      inp.Metadata,
+	}
+	if b.S3.Bodies == nil {
+		b.S3.Bodies = map[string][]byte{}
+	}
+	b.S3.Bodies[*inp.Key] = content
+	return &s3manager.UploadOutput{Location: *inp.Key}, nil
+}
+
+func (b *bucket) Download(w io.WriterAt, _ *s3.GetObjectInput, _ ...func(*s3manager.Downloader)) (int64, error) {
+	n, err := w.WriteAt(b.Content, 0)
+	return int64(n), err
+}
+
+func TestEncryptedUploadAndDownload(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte(strings.Repeat("very secret ", 10000))
+	path := filepath.Join(dir, "secret.txt")
+	assert.NoError(t, os.WriteFile(path, content, 0600))
+	sum := fmt.Sprintf("%x", sha256.Sum256(content))
+	key, err := envelope.NewKey("01234567890123456789012345678901")
+	assert.NoError(t, err)
+
+	client := &mockS3{Objects: map[string]*s3.HeadObjectOutput{}}
+	store := &bucket{S3: client}
+	u := Uploader{Client: store, S3: client, S3Bucket: "bucket", Encryption: key, Verify: true}
+	file := walker.SrcDest{
+		SourceFile:   path,
+		SourceSha256: sum,
+		DstObject:    "secret.txt",
+	}
+	assert.NoError(t, u.AddFileToS3(&file))
+	assert.Equal(t, walker.StatusUploaded, file.Status)
+	assert.Equal(t, envelope.EncryptedSize(int64(len(content))), int64(len(store.Content)))
+	assert.False(t, bytes.Contains(store.Content, []byte("secret")))
+	assert.Equal(t, envelope.ContentType, aws.StringValue(store.Input.ContentType))
+	assert.Equal(t, sum, aws.StringValue(store.Input.Metadata[MetaSHA256]))
+	assert.True(t, envelope.IsEncrypted(store.Input.Metadata))
+
+	// verification decrypts stored content, so damaged object is noticed
+	client.Bodies["secret.txt"][100] ^= 1
+	assert.ErrorIs(t, u.verify(&file, int64(len(content)), DefaultPartSize), errMismatch)
+	client.Bodies["secret.txt"][100] ^= 1
+
+	d := Downloader{Client: store, S3: client, S3Bucket: "bucket", Decryption: key}
+	down := walker.SrcDest{SourceFile: "secret.txt", DstObject: filepath.Join(dir, "restored.txt")}
+	assert.NoError(t, d.GetFileFromS3(&down))
+	restored, err := os.ReadFile(down.DstObject)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(content, restored))
+	// plaintext is described in the report
+	assert.Equal(t, uint64(len(content)), down.SourceSize)
+	assert.Equal(t, sum, down.SourceSha256)
+	// temporary files are removed
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	// objects which aren't encrypted aren't downloaded
+	client.Objects["plain.txt"] = &s3.HeadObjectOutput{}
+	plain := walker.SrcDest{SourceFile: "plain.txt", DstObject: filepath.Join(dir, "plain.txt")}
+	assert.ErrorContains(t, d.GetFileFromS3(&plain), "isn't encrypted")
+}
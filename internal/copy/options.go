@@ -0,0 +1,178 @@
+package copy
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// sniffLen is how many bytes http.DetectContentType looks at
+const sniffLen = 512
+
+// ObjectOptions are set on every uploaded object
+type ObjectOptions struct {
+	// ContentTypes overrides Content-Type by lower case file extension with leading dot
+	ContentTypes map[string]string
+	// ContentType replaces detected Content-Type, if it's not empty
+	ContentType        string
+	ContentDisposition string
+	CacheControl       string
+	ContentEncoding    string
+	ContentLanguage    string
+	// Expires isn't set if it's zero
+	Expires time.Time
+	// StorageClass like STANDARD_IA, empty means bucket default
+	StorageClass string
+	// ACL is canned ACL like bucket-owner-full-control
+	ACL string
+	// Tags are object tags
+	Tags map[string]string
+	// Metadata is user metadata (x-amz-meta-*) added to MetaSHA256
+	Metadata map[string]string
+}
+
+// ReadContentTypes reads CSV file with extension,content-type records
+func ReadContentTypes(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	in := csv.NewReader(f)
+	in.FieldsPerRecord = 2
+	in.TrimLeadingSpace = true
+	recs, err := in.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("can't read content types from %s: %w", path, err)
+	}
+	types := make(map[string]string, len(recs))
+	for _, rec := range recs {
+		ext := strings.ToLower(strings.TrimSpace(rec[0]))
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		types[ext] = strings.TrimSpace(rec[1])
+	}
+	return types, nil
+}
+
+// contentType returns Content-Type of file from ContentTypes or standard
+// types by its extension. If extension is unknown, content of r is sniffed.
+func (o *ObjectOptions) contentType(path string, r io.ReaderAt) string {
+	if len(o.ContentType) != 0 {
+		return o.ContentType
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if t, ok := o.ContentTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); len(ext) != 0 && len(t) != 0 {
+		return t
+	}
+	buf := make([]byte, sniffLen)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(buf[:n])
+}
+
+// withRow returns options of o overridden by options of CSV row
+func (o ObjectOptions) withRow(row *walker.Options) ObjectOptions {
+	if row == nil {
+		return o
+	}
+	if len(row.StorageClass) != 0 {
+		o.StorageClass = row.StorageClass
+	}
+	if len(row.ContentType) != 0 {
+		o.ContentType = row.ContentType
+	}
+	o.Tags = mergeMaps(o.Tags, row.Tags)
+	o.Metadata = mergeMaps(o.Metadata, row.Metadata)
+	return o
+}
+
+// validateRow checks that metadata and tags of CSV row together with global
+// ones fit S3 limits
+func (u *Uploader) validateRow(row *walker.Options) error {
+	if row == nil || len(row.Metadata) == 0 && len(row.Tags) == 0 {
+		return nil
+	}
+	opts := u.Object.withRow(row)
+	if err := walker.ValidateMetadata(opts.Metadata, u.Encryption != nil); err != nil {
+		return err
+	}
+	return walker.ValidateTags(opts.Tags)
+}
+
+// mergeMaps returns new map with values of both maps, b wins
+func mergeMaps(a, b map[string]string) map[string]string {
+	if len(b) == 0 {
+		return a
+	}
+	merged := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		merged[k] = v
+	}
+	for k, v := range b {
+		merged[k] = v
+	}
+	return merged
+}
+
+// apply sets headers of o, which aren't empty, on input
+func (o *ObjectOptions) apply(input *s3manager.UploadInput) {
+	if len(o.ContentDisposition) != 0 {
+		input.ContentDisposition = aws.String(o.ContentDisposition)
+	}
+	if len(o.CacheControl) != 0 {
+		input.CacheControl = aws.String(o.CacheControl)
+	}
+	if len(o.ContentEncoding) != 0 {
+		input.ContentEncoding = aws.String(o.ContentEncoding)
+	}
+	if len(o.ContentLanguage) != 0 {
+		input.ContentLanguage = aws.String(o.ContentLanguage)
+	}
+	if !o.Expires.IsZero() {
+		input.Expires = aws.Time(o.Expires)
+	}
+	if len(o.StorageClass) != 0 {
+		input.StorageClass = aws.String(o.StorageClass)
+	}
+	if len(o.ACL) != 0 {
+		input.ACL = aws.String(o.ACL)
+	}
+	if len(o.Tags) != 0 {
+		input.Tagging = aws.String(encodeTags(o.Tags))
+	}
+	for k, v := range o.Metadata {
+		if input.Metadata == nil {
+			input.Metadata = make(map[string]*string, len(o.Metadata))
+		}
+		input.Metadata[k] = aws.String(v)
+	}
+}
+
+// encodeTags returns tags encoded as URL query for Tagging parameter
+func encodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	// spaces are sent as %20, as S3 doesn't decode + in tags
+	return strings.ReplaceAll(values.Encode(), "+", "%20")
+}
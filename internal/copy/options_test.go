@@ -0,0 +1,178 @@
+package copy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/envelope"
+	"github.com/sarunask/s3-copy/internal/retry"
+	"github.com/sarunask/s3-copy/internal/ssec"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+func TestContentType(t *testing.T) {
+	t.Parallel()
+
+	o := ObjectOptions{ContentTypes: map[string]string{".mjs": "text/javascript"}}
+	cases := []struct {
+		Path    string
+		Content string
+		Type    string
+	}{
+		{Path: "index.html", Content: "", Type: "text/html; charset=utf-8"},
+		{Path: "INDEX.HTML", Content: "", Type: "text/html; charset=utf-8"},
+		{Path: "app.mjs", Content: "", Type: "text/javascript"},
+		{Path: "image", Content: "\x89PNG\x0D\x0A\x1A\x0A", Type: "image/png"},
+		{Path: "notes.unknown-ext", Content: "plain text", Type: "text/plain; charset=utf-8"},
+		{Path: "empty", Content: "", Type: "text/plain; charset=utf-8"},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.Type, o.contentType(c.Path, strings.NewReader(c.Content)),
+			fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestReadContentTypes(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "types.csv")
+	assert.NoError(t, os.WriteFile(path, []byte("mjs,text/javascript\n.WASM, application/wasm\n"), 0600))
+	types, err := ReadContentTypes(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{".mjs": "text/javascript", ".wasm": "application/wasm"}, types)
+
+	assert.NoError(t, os.WriteFile(path, []byte("mjs\n"), 0600))
+	_, err = ReadContentTypes(path)
+	assert.Error(t, err)
+}
+
+func TestAddFileToS3Headers(t *testing.T) {
+	expires := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	client := &flakyS3Manager{}
+	u := Uploader{
+		Client:   client,
+		S3Bucket: "bucket",
+		Object: ObjectOptions{
+			ContentDisposition: "attachment",
+			CacheControl:       "max-age=3600",
+			ContentLanguage:    "lt",
+			Expires:            expires,
+		},
+	}
+	path := filepath.Join(t.TempDir(), "index.html")
+	assert.NoError(t, os.WriteFile(path, []byte("<html></html>"), 0600))
+	assert.NoError(t, u.AddFileToS3(&walker.SrcDest{SourceFile: path, DstObject: "index.html"}))
+	input := client.Inputs[0]
+	assert.Equal(t, "text/html; charset=utf-8", aws.StringValue(input.ContentType))
+	assert.Equal(t, "attachment", aws.StringValue(input.ContentDisposition))
+	assert.Equal(t, "max-age=3600", aws.StringValue(input.CacheControl))
+	assert.Equal(t, "lt", aws.StringValue(input.ContentLanguage))
+	assert.Nil(t, input.ContentEncoding)
+	assert.Equal(t, expires, aws.TimeValue(input.Expires))
+}
+
+func TestApplyStorageOptions(t *testing.T) {
+	t.Parallel()
+
+	o := ObjectOptions{
+		StorageClass: "DEEP_ARCHIVE",
+		ACL:          "bucket-owner-full-control",
+		Tags:         map[string]string{"team": "data ops", "env": "prod"},
+		Metadata:     map[string]string{"origin": "host-1"},
+	}
+	input := &s3manager.UploadInput{Metadata: map[string]*string{MetaSHA256: aws.String("abc")}}
+	o.apply(input)
+	assert.Equal(t, "DEEP_ARCHIVE", aws.StringValue(input.StorageClass))
+	assert.Equal(t, "bucket-owner-full-control", aws.StringValue(input.ACL))
+	assert.Equal(t, "env=prod&team=data%20ops", aws.StringValue(input.Tagging))
+	assert.Equal(t, map[string]string{MetaSHA256: "abc", "origin": "host-1"}, aws.StringValueMap(input.Metadata))
+
+	// nothing is set by default
+	input = &s3manager.UploadInput{}
+	(&ObjectOptions{}).apply(input)
+	assert.Equal(t, &s3manager.UploadInput{}, input)
+}
+
+func TestAddFileToS3RowOptions(t *testing.T) {
+	client := &flakyS3Manager{}
+	u := Uploader{
+		Client:    client,
+		S3Bucket:  "bucket",
+		S3SSEC:    "AES256",
+		S3SSECKey: "global",
+		Object: ObjectOptions{
+			StorageClass: "STANDARD_IA",
+			Tags:         map[string]string{"team": "data"},
+			Metadata:     map[string]string{"origin": "host-1"},
+		},
+	}
+	file := walker.SrcDest{
+		SourceFile: "./copy.go",
+		DstObject:  "copy.go",
+		Options: &walker.Options{
+			StorageClass: "DEEP_ARCHIVE",
+			ContentType:  "text/plain",
+			Tags:         map[string]string{"delivery": "42"},
+			Metadata:     map[string]string{"origin": "manifest"},
+			SSECKey:      "row",
+		},
+	}
+	assert.NoError(t, u.AddFileToS3(&file))
+	input := client.Inputs[0]
+	assert.Equal(t, "DEEP_ARCHIVE", aws.StringValue(input.StorageClass))
+	assert.Equal(t, "text/plain", aws.StringValue(input.ContentType))
+	assert.Equal(t, "delivery=42&team=data", aws.StringValue(input.Tagging))
+	assert.Equal(t, "manifest", aws.StringValue(input.Metadata["origin"]))
+	assert.Equal(t, "row", aws.StringValue(input.SSECustomerKey))
+	assert.Equal(t, ssec.Fingerprint("row"), file.KeyMD5)
+	// global options aren't changed by row
+	assert.Equal(t, map[string]string{"team": "data"}, u.Object.Tags)
+
+	// row is checked together with global tags and metadata
+	tags := map[string]string{}
+	for i := 0; i < 10; i++ {
+		tags[fmt.Sprintf("t%d", i)] = "x"
+	}
+	file.Options = &walker.Options{Tags: tags}
+	assert.ErrorContains(t, u.AddFileToS3(&file), "up to 10 tags")
+	assert.Equal(t, string(retry.ClassFatal), file.ErrorClass)
+	file.Options = &walker.Options{Metadata: map[string]string{"origin": strings.Repeat("x", 1900)}}
+	assert.NoError(t, u.AddFileToS3(&file))
+	u.Encryption = &envelope.Key{}
+	assert.ErrorContains(t, u.AddFileToS3(&file), "up to 2048 bytes")
+	assert.Len(t, client.Inputs, 2)
+}
+
+func TestUploadStream(t *testing.T) {
+	client := &mockS3{Objects: map[string]*s3.HeadObjectOutput{}}
+	store := &bucket{S3: client}
+	u := Uploader{
+		Client:            store,
+		S3Bucket:          "bucket",
+		S3SSEC:            "AES256",
+		S3SSECKey:         "global",
+		ChecksumAlgorithm: "SHA256",
+		Object:            ObjectOptions{StorageClass: "STANDARD_IA", Tags: map[string]string{"team": "data"}},
+		Compression:       &Compression{Suffix: true},
+	}
+	assert.NoError(t, u.UploadStream("shards/0.tar", "application/x-tar", strings.NewReader("tar"), 3))
+	input := store.Input
+	assert.Equal(t, "shards/0.tar", aws.StringValue(input.Key))
+	assert.Equal(t, "application/x-tar", aws.StringValue(input.ContentType))
+	assert.Equal(t, "global", aws.StringValue(input.SSECustomerKey))
+	assert.Equal(t, "STANDARD_IA", aws.StringValue(input.StorageClass))
+	assert.Equal(t, "team=data", aws.StringValue(input.Tagging))
+	assert.Nil(t, input.ChecksumAlgorithm)
+	assert.Nil(t, input.ContentEncoding)
+	assert.Empty(t, input.Metadata)
+	assert.Equal(t, "tar", string(store.Content))
+}
@@ -0,0 +1,44 @@
+package copy
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/sarunask/s3-copy/internal/units"
+)
+
+const (
+	// DefaultPartSize is size of parts for multipart uploads, unless file
+	// is too big to fit into MaxUploadParts of that size
+	DefaultPartSize = 10 * units.MiB
+	// MaxPartSize is the biggest part S3 accepts
+	MaxPartSize = 5 * units.GiB
+	// MaxObjectSize is the biggest object S3 accepts
+	MaxObjectSize = 5 * units.TiB
+)
+
+// PartSize returns part size for file of size. If requested is zero
+// DefaultPartSize is used. If file needs more than s3manager.MaxUploadParts
+// parts of that size, part size is increased to the smallest whole MiB
+// which fits.
+func PartSize(size, requested int64) (int64, error) {
+	if size > MaxObjectSize {
+		return 0, fmt.Errorf("size %d is bigger than %d bytes allowed by S3", size, MaxObjectSize)
+	}
+	partSize := requested
+	if partSize == 0 {
+		partSize = DefaultPartSize
+	}
+	if partsCount(size, partSize) <= s3manager.MaxUploadParts {
+		return partSize, nil
+	}
+	minPartSize := (size + s3manager.MaxUploadParts - 1) / s3manager.MaxUploadParts
+	adjusted := (minPartSize + units.MiB - 1) / units.MiB * units.MiB
+	if requested != 0 {
+		log.Infof("part size %d is too small for %d bytes, using %d", requested, size, adjusted)
+	}
+	return adjusted, nil
+}
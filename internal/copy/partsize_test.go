@@ -0,0 +1,40 @@
+package copy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/units"
+)
+
+func TestPartSize(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Size      int64
+		Requested int64
+		PartSize  int64
+		Fail      bool
+	}{
+		{Size: units.MiB, PartSize: DefaultPartSize},
+		{Size: 10000 * DefaultPartSize, PartSize: DefaultPartSize},
+		{Size: 10000*DefaultPartSize + 1, PartSize: DefaultPartSize + units.MiB},
+		{Size: 200 * units.GiB, Requested: 64 * units.MiB, PartSize: 64 * units.MiB},
+		{Size: 1 * units.TiB, Requested: 64 * units.MiB, PartSize: 105 * units.MiB},
+		{Size: MaxObjectSize, PartSize: 525 * units.MiB},
+		{Size: MaxObjectSize + 1, Fail: true},
+	}
+	for i, c := range cases {
+		partSize, err := PartSize(c.Size, c.Requested)
+		if c.Fail {
+			assert.Error(t, err, fmt.Sprintf("iteration %d", i))
+			continue
+		}
+		assert.NoError(t, err, fmt.Sprintf("iteration %d", i))
+		assert.Equal(t, c.PartSize, partSize, fmt.Sprintf("they should be equal in iteration %d", i))
+		assert.LessOrEqual(t, partsCount(c.Size, partSize), int64(10000))
+		assert.LessOrEqual(t, partSize, MaxPartSize)
+	}
+}
@@ -0,0 +1,475 @@
+package copy
+
+import (
+	"crypto/md5" // nolint:gosec
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/sarunask/s3-copy/internal/envelope"
+	"github.com/sarunask/s3-copy/internal/throttle"
+)
+
+// errNotResumable is returned when parts of existing upload don't fit the local file
+var errNotResumable = errors.New("existing parts don't match local file")
+
+// findUpload returns most recent multipart upload in progress for exactly
+// the same key or nil if there is no such upload
+func (u *Uploader) findUpload(bucket, key string) (*s3.MultipartUpload, error) {
+	var found *s3.MultipartUpload
+	err := u.S3.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(key),
+	}, func(page *s3.ListMultipartUploadsOutput, _ bool) bool {
+		for _, upload := range page.Uploads {
+			if aws.StringValue(upload.Key) != key {
+				continue
+			}
+			if found == nil || aws.TimeValue(upload.Initiated).After(aws.TimeValue(found.Initiated)) {
+				found = upload
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't list multipart uploads for %s: %w", key, err)
+	}
+	return found, nil
+}
+
+// listParts returns all parts, which were already uploaded for upload
+func (u *Uploader) listParts(input *s3manager.UploadInput, uploadID string) ([]*s3.Part, error) {
+	var parts []*s3.Part
+	err := u.S3.ListPartsPages(&s3.ListPartsInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		UploadId:             aws.String(uploadID),
+		SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+		SSECustomerKey:       input.SSECustomerKey,
+	}, func(page *s3.ListPartsOutput, _ bool) bool {
+		parts = append(parts, page.Parts...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't list parts of upload %s: %w", uploadID, err)
+	}
+	return parts, nil
+}
+
+// partLayout returns size and offset of part n when file of size is split
+// into parts of partSize
+func partLayout(n, size, partSize int64) (offset, length int64) {
+	offset = (n - 1) * partSize
+	length = partSize
+	if offset+length > size {
+		length = size - offset
+	}
+	return offset, length
+}
+
+// partsCount returns how many parts of partSize are needed for size
+func partsCount(size, partSize int64) int64 {
+	return (size + partSize - 1) / partSize
+}
+
+// partSizes returns part sizes, which could have been used for already
+// uploaded parts. All parts except the last one have the same size, so it's
+// size of the biggest part or, if only the short last part is listed, size
+// which puts the last part at the end of file.
+func partSizes(parts []*s3.Part, size int64) ([]int64, error) {
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	var biggest int64
+	last := parts[0]
+	for _, p := range parts {
+		if aws.Int64Value(p.Size) > biggest {
+			biggest = aws.Int64Value(p.Size)
+		}
+		if aws.Int64Value(p.PartNumber) > aws.Int64Value(last.PartNumber) {
+			last = p
+		}
+	}
+	candidates := []int64{biggest}
+	if n := aws.Int64Value(last.PartNumber); n > 1 {
+		if rest := size - aws.Int64Value(last.Size); rest > 0 && rest%(n-1) == 0 && rest/(n-1) != biggest {
+			candidates = append(candidates, rest/(n-1))
+		}
+	}
+	var (
+		sizes []int64
+		err   error
+	)
+	for _, candidate := range candidates {
+		if candidateErr := checkPartSize(parts, size, candidate); candidateErr != nil {
+			err = candidateErr
+			continue
+		}
+		sizes = append(sizes, candidate)
+	}
+	if len(sizes) == 0 {
+		return nil, err
+	}
+	return sizes, nil
+}
+
+// checkPartSize checks if all parts have size and position they would have
+// when file of size is split into parts of partSize
+func checkPartSize(parts []*s3.Part, size, partSize int64) error {
+	if partSize < s3manager.MinUploadPartSize && partSize < size {
+		return fmt.Errorf("%w: part size %d is too small", errNotResumable, partSize)
+	}
+	if partsCount(size, partSize) > s3manager.MaxUploadParts {
+		return fmt.Errorf("%w: part size %d needs too many parts", errNotResumable, partSize)
+	}
+	for _, p := range parts {
+		n := aws.Int64Value(p.PartNumber)
+		if n > partsCount(size, partSize) {
+			return fmt.Errorf("%w: part %d is beyond end of file", errNotResumable, n)
+		}
+		_, length := partLayout(n, size, partSize)
+		if aws.Int64Value(p.Size) != length {
+			return fmt.Errorf("%w: part %d has %d bytes instead of %d",
+				errNotResumable, n, aws.Int64Value(p.Size), length)
+		}
+	}
+	return nil
+}
+
+// partMatches checks if uploaded part has the same content as local file.
+// If upload has additional checksums, they are compared. Otherwise ETag of
+// a part is MD5 of its content. resume makes sure that it's one of them.
+func partMatches(f io.ReaderAt, p *s3.Part, partSize, size int64, input *s3manager.UploadInput) (bool, error) {
+	offset, length := partLayout(aws.Int64Value(p.PartNumber), size, partSize)
+	if alg := aws.StringValue(input.ChecksumAlgorithm); len(alg) != 0 {
+		sum, err := checksumOf(alg, io.NewSectionReader(f, offset, length))
+		if err != nil {
+			return false, err
+		}
+		return partChecksum(p, alg) == sum, nil
+	}
+	h := md5.New() // nolint:gosec
+	if _, err := io.Copy(h, io.NewSectionReader(f, offset, length)); err != nil {
+		return false, err
+	}
+	return strings.Trim(aws.StringValue(p.ETag), `"`) == fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// matchingParts returns completed parts of upload, which was split into
+// parts of partSize, or nil if some part differs from local file
+func matchingParts(
+	f *os.File,
+	parts []*s3.Part,
+	partSize, size int64,
+	input *s3manager.UploadInput,
+) (map[int64]*s3.CompletedPart, error) {
+	completed := make(map[int64]*s3.CompletedPart, len(parts))
+	for _, p := range parts {
+		ok, err := partMatches(f, p, partSize, size, input)
+		if err != nil {
+			return nil, fmt.Errorf("can't read part %d of %s: %w", aws.Int64Value(p.PartNumber), f.Name(), err)
+		}
+		if !ok {
+			log.Debugf("part %d of %s differs from local file with part size %d",
+				aws.Int64Value(p.PartNumber), f.Name(), partSize)
+			return nil, nil
+		}
+		completed[aws.Int64Value(p.PartNumber)] = &s3.CompletedPart{
+			ETag:           p.ETag,
+			PartNumber:     p.PartNumber,
+			ChecksumCRC32:  p.ChecksumCRC32,
+			ChecksumCRC32C: p.ChecksumCRC32C,
+			ChecksumSHA1:   p.ChecksumSHA1,
+			ChecksumSHA256: p.ChecksumSHA256,
+		}
+	}
+	return completed, nil
+}
+
+// resume uploads parts missing in existing multipart upload and completes it.
+// Part size is taken from already uploaded parts and defaultPartSize is used
+// only when there are none. If parts fit several part sizes, the one with
+// matching content is used. It returns part size which was used. Upload
+// is resumed only if every uploaded part matches local file, which wasn't
+// modified since upload was started, as object gets metadata of the upload.
+// ETag of SSE-C and SSE-KMS encrypted parts, including those encrypted by
+// default encryption of bucket, isn't MD5 of their content, so such uploads
+// are resumed only with additional checksums.
+func (u *Uploader) resume(
+	f *os.File,
+	size int64,
+	input *s3manager.UploadInput,
+	upload *s3.MultipartUpload,
+	defaultPartSize int64,
+) (int64, error) {
+	uploadID := aws.StringValue(upload.UploadId)
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("can't get info for %s: %w", f.Name(), err)
+	}
+	if info.ModTime().After(aws.TimeValue(upload.Initiated)) {
+		return 0, fmt.Errorf("%w: file was modified after upload was started", errNotResumable)
+	}
+	if aws.StringValue(upload.ChecksumAlgorithm) != aws.StringValue(input.ChecksumAlgorithm) {
+		return 0, fmt.Errorf("%w: upload has '%s' checksum algorithm instead of '%s'", errNotResumable,
+			aws.StringValue(upload.ChecksumAlgorithm), aws.StringValue(input.ChecksumAlgorithm))
+	}
+	if len(aws.StringValue(input.ChecksumAlgorithm)) == 0 && !etagIsMD5(u.uploadSSE(input), input.SSECustomerAlgorithm) {
+		return 0, fmt.Errorf("%w: encrypted parts can't be checked without checksum algorithm", errNotResumable)
+	}
+	parts, err := u.listParts(input, uploadID)
+	if err != nil {
+		return 0, err
+	}
+	sizes, err := partSizes(parts, size)
+	if err != nil {
+		return 0, err
+	}
+	if len(sizes) == 0 {
+		sizes = []int64{defaultPartSize}
+	}
+	var (
+		partSize  int64
+		completed map[int64]*s3.CompletedPart
+	)
+	for _, candidate := range sizes {
+		if completed, err = matchingParts(f, parts, candidate, size, input); err != nil {
+			return 0, err
+		}
+		if completed != nil {
+			partSize = candidate
+			break
+		}
+	}
+	if completed == nil {
+		return 0, fmt.Errorf("%w: parts differ from local file", errNotResumable)
+	}
+	log.Infof("resuming upload %s of %s: %d of %d parts are already uploaded",
+		uploadID, f.Name(), len(completed), partsCount(size, partSize))
+	return partSize, u.uploadParts(f, size, input, uploadID, partSize, completed)
+}
+
+// uploadMultipart uploads f in parts with their additional checksums,
+// which s3manager doesn't send
+func (u *Uploader) uploadMultipart(f *os.File, size int64, input *s3manager.UploadInput, partSize int64) error {
+	params := &s3.CreateMultipartUploadInput{}
+	awsutil.Copy(params, input)
+	resp, err := u.S3.CreateMultipartUpload(params)
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	return u.uploadParts(f, size, input, aws.StringValue(resp.UploadId), partSize, map[int64]*s3.CompletedPart{})
+}
+
+// uploadPart uploads part n of f
+func (u *Uploader) uploadPart(
+	f *os.File,
+	size int64,
+	input *s3manager.UploadInput,
+	uploadID string,
+	partSize, n int64,
+) (*s3.CompletedPart, error) {
+	offset, length := partLayout(n, size, partSize)
+	params := &s3.UploadPartInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		UploadId:             aws.String(uploadID),
+		PartNumber:           aws.Int64(n),
+		Body:                 io.NewSectionReader(throttle.NewFile(f, u.Bandwidth), offset, length),
+		ContentLength:        aws.Int64(length),
+		SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+		SSECustomerKey:       input.SSECustomerKey,
+	}
+	var c checksums
+	if alg := aws.StringValue(input.ChecksumAlgorithm); len(alg) != 0 {
+		sum, err := checksumOf(alg, io.NewSectionReader(f, offset, length))
+		if err != nil {
+			return nil, err
+		}
+		c = newChecksums(alg, sum)
+		params.ChecksumAlgorithm = input.ChecksumAlgorithm
+		params.ChecksumCRC32 = c.CRC32
+		params.ChecksumCRC32C = c.CRC32C
+		params.ChecksumSHA1 = c.SHA1
+		params.ChecksumSHA256 = c.SHA256
+	}
+	resp, err := u.S3.UploadPart(params)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.CompletedPart{
+		ETag:           resp.ETag,
+		PartNumber:     aws.Int64(n),
+		ChecksumCRC32:  c.CRC32,
+		ChecksumCRC32C: c.CRC32C,
+		ChecksumSHA1:   c.SHA1,
+		ChecksumSHA256: c.SHA256,
+	}, nil
+}
+
+// uploadParts uploads parts which are not in completed yet and completes upload
+func (u *Uploader) uploadParts(
+	f *os.File,
+	size int64,
+	input *s3manager.UploadInput,
+	uploadID string,
+	partSize int64,
+	completed map[int64]*s3.CompletedPart,
+) error {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		sendErr error
+	)
+	sem := make(chan struct{}, u.partConcurrency())
+	for n := int64(1); n <= partsCount(size, partSize); n++ {
+		if _, ok := completed[n]; ok {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(n int64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			part, err := u.uploadPart(f, size, input, uploadID, partSize, n)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if sendErr == nil {
+					sendErr = fmt.Errorf("failed to upload part %d: %w", n, err)
+				}
+				return
+			}
+			completed[n] = part
+		}(n)
+	}
+	wg.Wait()
+	if sendErr != nil {
+		// parts uploaded so far are kept, so next attempt could resume again
+		return sendErr
+	}
+
+	completedParts := make([]*s3.CompletedPart, 0, len(completed))
+	for _, p := range completed {
+		completedParts = append(completedParts, p)
+	}
+	sort.Slice(completedParts, func(i, j int) bool {
+		return aws.Int64Value(completedParts[i].PartNumber) < aws.Int64Value(completedParts[j].PartNumber)
+	})
+	_, err := u.S3.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		UploadId:             aws.String(uploadID),
+		MultipartUpload:      &s3.CompletedMultipartUpload{Parts: completedParts},
+		SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+		SSECustomerKey:       input.SSECustomerKey,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete upload %s: %w", uploadID, err)
+	}
+	return nil
+}
+
+// objectDiffers returns how object uploaded with input differs from
+// metadata, headers, storage class, encryption and tags of input or empty
+// string if it doesn't. Resumed object has them from the upload it completed.
+func (u *Uploader) objectDiffers(input *s3manager.UploadInput) (string, error) {
+	head, err := u.S3.HeadObject(&s3.HeadObjectInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+		SSECustomerKey:       input.SSECustomerKey,
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't get info of %s: %w", aws.StringValue(input.Key), err)
+	}
+	if len(head.Metadata) != len(input.Metadata) {
+		return "metadata", nil
+	}
+	for k, v := range input.Metadata {
+		if envelope.MetadataValue(head.Metadata, k) != aws.StringValue(v) {
+			return "metadata " + k, nil
+		}
+	}
+	headers := []struct {
+		name          string
+		want, present *string
+	}{
+		{"Content-Type", input.ContentType, head.ContentType},
+		{"Content-Disposition", input.ContentDisposition, head.ContentDisposition},
+		{"Content-Encoding", input.ContentEncoding, head.ContentEncoding},
+		{"Content-Language", input.ContentLanguage, head.ContentLanguage},
+		{"Cache-Control", input.CacheControl, head.CacheControl},
+	}
+	for _, h := range headers {
+		if aws.StringValue(h.want) != aws.StringValue(h.present) {
+			return h.name, nil
+		}
+	}
+	if input.Expires != nil && input.Expires.UTC().Format(http.TimeFormat) != aws.StringValue(head.Expires) {
+		return "Expires", nil
+	}
+	// S3 doesn't return storage class of STANDARD objects
+	if class := aws.StringValue(input.StorageClass); class != aws.StringValue(head.StorageClass) &&
+		(class != s3.StorageClassStandard || head.StorageClass != nil) {
+		return "storage class", nil
+	}
+	// without algorithm object is encrypted by bucket default
+	if input.ServerSideEncryption != nil && aws.StringValue(input.ServerSideEncryption) != aws.StringValue(head.ServerSideEncryption) {
+		return "server-side encryption", nil
+	}
+	return u.tagsDiffer(input)
+}
+
+// tagsDiffer returns "tags" if tags of object differ from tags of input
+func (u *Uploader) tagsDiffer(input *s3manager.UploadInput) (string, error) {
+	resp, err := u.S3.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: input.Bucket,
+		Key:    input.Key,
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't get tags of %s: %w", aws.StringValue(input.Key), err)
+	}
+	want, err := url.ParseQuery(aws.StringValue(input.Tagging))
+	if err != nil {
+		return "", fmt.Errorf("bad tags of %s: %w", aws.StringValue(input.Key), err)
+	}
+	if len(resp.TagSet) != len(want) {
+		return "tags", nil
+	}
+	for _, tag := range resp.TagSet {
+		if want.Get(aws.StringValue(tag.Key)) != aws.StringValue(tag.Value) {
+			return "tags", nil
+		}
+	}
+	return "", nil
+}
+
+// abortUpload removes upload, which can't be resumed, so its parts don't
+// stay in bucket
+func (u *Uploader) abortUpload(input *s3manager.UploadInput, upload *s3.MultipartUpload) {
+	_, err := u.S3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   input.Bucket,
+		Key:      input.Key,
+		UploadId: upload.UploadId,
+	})
+	if err != nil {
+		log.Warnf("can't abort upload %s of %s: %v",
+			aws.StringValue(upload.UploadId), aws.StringValue(input.Key), err)
+	}
+}
@@ -0,0 +1,459 @@
+package copy
+
+import (
+	"bytes"
+	"crypto/md5" // nolint:gosec
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+const testPartSize = s3manager.MinUploadPartSize
+
+type mockS3 struct {
+	s3iface.S3API
+	mu        sync.Mutex
+	Uploads   []*s3.MultipartUpload
+	Parts     []*s3.Part
+	Sent      []int64
+	Completed []*s3.CompletedPart
+	Aborted   []string
+	Objects   map[string]*s3.HeadObjectOutput
+	Created   []*s3.CreateMultipartUploadInput
+	SentParts []*s3.UploadPartInput
+	Copied    []*s3.CopyObjectInput
+	PartCopy  []*s3.UploadPartCopyInput
+	CopyErr   error
+	// Keys has SSE-C keys of Objects, HEAD with other key fails
+	Keys map[string]string
+	// Tags has tags of Objects
+	Tags map[string][]*s3.Tag
+	// Bodies has content of Objects, which are downloaded
+	Bodies map[string][]byte
+	// UploadHeads has HEAD responses of objects of completed uploads by
+	// their IDs, objects of other uploads get metadata they were created with
+	UploadHeads map[string]*s3.HeadObjectOutput
+	// BucketSSE is default encryption algorithm of bucket
+	BucketSSE string
+}
+
+func (m *mockS3) GetBucketEncryption(_ *s3.GetBucketEncryptionInput) (*s3.GetBucketEncryptionOutput, error) {
+	if len(m.BucketSSE) == 0 {
+		return nil, awserr.NewRequestFailure(awserr.New("ServerSideEncryptionConfigurationNotFoundError",
+			"The server side encryption configuration was not found", nil), 404, "id")
+	}
+	return &s3.GetBucketEncryptionOutput{
+		ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+			Rules: []*s3.ServerSideEncryptionRule{{
+				ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{SSEAlgorithm: aws.String(m.BucketSSE)},
+			}},
+		},
+	}, nil
+}
+
+func (m *mockS3) CreateMultipartUpload(inp *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	m.Created = append(m.Created, inp)
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("created")}, nil
+}
+
+func (m *mockS3) HeadObject(inp *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	head, ok := m.Objects[*inp.Key]
+	if !ok {
+		return nil, awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), 404, "id")
+	}
+	if key, ok := m.Keys[*inp.Key]; ok && key != aws.StringValue(inp.SSECustomerKey) {
+		return nil, awserr.NewRequestFailure(awserr.New("BadRequest", "Bad Request", nil), 400, "id")
+	}
+	return head, nil
+}
+
+func (m *mockS3) GetObjectWithContext(_ aws.Context, inp *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	body, ok := m.Bodies[*inp.Key]
+	if !ok {
+		return nil, awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "Not Found", nil), 404, "id")
+	}
+	return &s3.GetObjectOutput{
+		Body:     io.NopCloser(bytes.NewReader(body)),
+		Metadata: m.Objects[*inp.Key].Metadata,
+	}, nil
+}
+
+func (m *mockS3) ListMultipartUploadsPages(_ *s3.ListMultipartUploadsInput, fn func(*s3.ListMultipartUploadsOutput, bool) bool) error {
+	fn(&s3.ListMultipartUploadsOutput{Uploads: m.Uploads}, true)
+	return nil
+}
+
+func (m *mockS3) ListPartsPages(_ *s3.ListPartsInput, fn func(*s3.ListPartsOutput, bool) bool) error {
+	fn(&s3.ListPartsOutput{Parts: m.Parts}, true)
+	return nil
+}
+
+func (m *mockS3) UploadPart(inp *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	sum, err := md5Of(inp.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Sent = append(m.Sent, *inp.PartNumber)
+	m.SentParts = append(m.SentParts, inp)
+	return &s3.UploadPartOutput{ETag: aws.String(sum)}, nil
+}
+
+func (m *mockS3) CompleteMultipartUpload(inp *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	m.Completed = inp.MultipartUpload.Parts
+	head, ok := m.UploadHeads[*inp.UploadId]
+	if !ok {
+		head = &s3.HeadObjectOutput{}
+		for _, created := range m.Created {
+			if *created.Key == *inp.Key {
+				head = &s3.HeadObjectOutput{Metadata: created.Metadata, ContentType: created.ContentType}
+			}
+		}
+	}
+	if m.Objects == nil {
+		m.Objects = map[string]*s3.HeadObjectOutput{}
+	}
+	m.Objects[*inp.Key] = head
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (m *mockS3) AbortMultipartUpload(inp *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	m.Aborted = append(m.Aborted, *inp.UploadId)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func md5Of(r io.Reader) (string, error) {
+	h := md5.New() // nolint:gosec
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum(nil)), nil
+}
+
+// createTestFile creates file with 3 full parts and a bit more
+func createTestFile(t *testing.T) (*os.File, int64) {
+	f, err := os.CreateTemp("", "resume-")
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		f.Close()
+		_ = os.Remove(f.Name())
+	})
+	buf := make([]byte, testPartSize)
+	for i := 0; i < 3; i++ {
+		for j := range buf {
+			buf[j] = byte(i + j)
+		}
+		_, err = f.Write(buf)
+		assert.NoError(t, err)
+	}
+	_, err = f.Write([]byte("the last part"))
+	assert.NoError(t, err)
+	return f, 3*testPartSize + 13
+}
+
+func partOf(t *testing.T, f *os.File, n, size int64, etag string) *s3.Part {
+	offset, length := partLayout(n, size, testPartSize)
+	if etag == "" {
+		var err error
+		etag, err = md5Of(io.NewSectionReader(f, offset, length))
+		assert.NoError(t, err)
+	}
+	return &s3.Part{
+		PartNumber: aws.Int64(n),
+		Size:       aws.Int64(length),
+		ETag:       aws.String(etag),
+	}
+}
+
+func TestResume(t *testing.T) {
+	f, size := createTestFile(t)
+	client := &mockS3{
+		Uploads: []*s3.MultipartUpload{
+			{Key: aws.String("dir/other"), UploadId: aws.String("other"), Initiated: aws.Time(time.Now())},
+			{Key: aws.String("dir/file"), UploadId: aws.String("old"), Initiated: aws.Time(time.Now().Add(-time.Hour))},
+			{Key: aws.String("dir/file"), UploadId: aws.String("new"), Initiated: aws.Time(time.Now())},
+		},
+	}
+	// parts 1 and 3 match local file, 2 with 4 are missing
+	client.Parts = []*s3.Part{
+		partOf(t, f, 1, size, ""),
+		partOf(t, f, 3, size, ""),
+	}
+	u := Uploader{S3: client}
+	input := &s3manager.UploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("dir/file"),
+	}
+	resumedPartSize, err := u.tryResume(f, size, input, testPartSize)
+	assert.NoError(t, err)
+	assert.Equal(t, testPartSize, resumedPartSize)
+	sort.Slice(client.Sent, func(i, j int) bool { return client.Sent[i] < client.Sent[j] })
+	assert.Equal(t, []int64{2, 4}, client.Sent)
+	assert.Len(t, client.Completed, 4)
+	for i, p := range client.Completed {
+		assert.Equal(t, partOf(t, f, int64(i+1), size, "").ETag, p.ETag)
+	}
+	assert.Empty(t, client.Aborted)
+}
+
+func TestResumeAbortsChangedUpload(t *testing.T) {
+	f, size := createTestFile(t)
+	cases := []struct {
+		Parts     []*s3.Part
+		Input     *s3manager.UploadInput
+		BucketSSE string
+	}{
+		{
+			// part 3 differs from local file, so upload was made for other content
+			Parts: []*s3.Part{partOf(t, f, 1, size, ""), partOf(t, f, 3, size, `"0123456789abcdef0123456789abcdef"`)},
+			Input: &s3manager.UploadInput{},
+		},
+		{
+			// ETag of SSE-C parts isn't MD5, so they can't be checked
+			Parts: []*s3.Part{partOf(t, f, 1, size, "")},
+			Input: &s3manager.UploadInput{SSECustomerAlgorithm: aws.String("AES256"), SSECustomerKey: aws.String("key")},
+		},
+		{
+			Parts: []*s3.Part{partOf(t, f, 1, size, "")},
+			Input: &s3manager.UploadInput{ServerSideEncryption: aws.String(s3.ServerSideEncryptionAwsKms)},
+		},
+		{
+			// parts are encrypted by default encryption of bucket
+			Parts:     []*s3.Part{partOf(t, f, 1, size, "")},
+			Input:     &s3manager.UploadInput{},
+			BucketSSE: s3.ServerSideEncryptionAwsKms,
+		},
+	}
+	for i, c := range cases {
+		client := &mockS3{
+			Uploads: []*s3.MultipartUpload{
+				{Key: aws.String("dir/file"), UploadId: aws.String("changed"), Initiated: aws.Time(time.Now())},
+			},
+			Parts:     c.Parts,
+			BucketSSE: c.BucketSSE,
+		}
+		u := Uploader{S3: client}
+		c.Input.Bucket = aws.String("bucket")
+		c.Input.Key = aws.String("dir/file")
+		resumedPartSize, err := u.tryResume(f, size, c.Input, testPartSize)
+		msg := fmt.Sprintf("they should be equal in iteration %d", i)
+		assert.NoError(t, err, msg)
+		assert.Zero(t, resumedPartSize, msg)
+		assert.Equal(t, []string{"changed"}, client.Aborted, msg)
+		assert.Empty(t, client.Sent, msg)
+		assert.Empty(t, client.Completed, msg)
+	}
+}
+
+func TestPartSizes(t *testing.T) {
+	t.Parallel()
+
+	const mib = 1024 * 1024
+	part := func(n, size int64) *s3.Part {
+		return &s3.Part{PartNumber: aws.Int64(n), Size: aws.Int64(size)}
+	}
+	cases := []struct {
+		Parts []*s3.Part
+		Size  int64
+		Sizes []int64
+		Fail  bool
+	}{
+		{Parts: nil, Size: 30 * mib, Sizes: nil},
+		{Parts: []*s3.Part{part(1, 8*mib), part(4, 6*mib)}, Size: 30 * mib, Sizes: []int64{8 * mib}},
+		// only short last part is listed, it could also be full part of 6MiB parts
+		{Parts: []*s3.Part{part(4, 6*mib)}, Size: 30 * mib, Sizes: []int64{6 * mib, 8 * mib}},
+		{Parts: []*s3.Part{part(2, mib)}, Size: 9 * mib, Sizes: []int64{8 * mib}},
+		{Parts: []*s3.Part{part(2, 8*mib)}, Size: 30 * mib, Sizes: []int64{8 * mib, 22 * mib}},
+		{Parts: []*s3.Part{part(1, 8*mib), part(2, 7*mib)}, Size: 30 * mib, Fail: true},
+		{Parts: []*s3.Part{part(1, mib)}, Size: 30 * mib, Fail: true},
+	}
+	for i, c := range cases {
+		sizes, err := partSizes(c.Parts, c.Size)
+		msg := fmt.Sprintf("they should be equal in iteration %d", i)
+		assert.Equal(t, c.Fail, err != nil, msg)
+		assert.Equal(t, c.Sizes, sizes, msg)
+	}
+}
+
+func TestResumeOnlyLastPart(t *testing.T) {
+	f, size := createTestFile(t)
+	client := &mockS3{
+		Uploads: []*s3.MultipartUpload{
+			{Key: aws.String("dir/file"), UploadId: aws.String("id"), Initiated: aws.Time(time.Now())},
+		},
+		Parts: []*s3.Part{partOf(t, f, 4, size, "")},
+	}
+	u := Uploader{S3: client}
+	resumedPartSize, err := u.tryResume(f, size, &s3manager.UploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("dir/file"),
+	}, 2*testPartSize)
+	assert.NoError(t, err)
+	assert.Equal(t, testPartSize, resumedPartSize)
+	sort.Slice(client.Sent, func(i, j int) bool { return client.Sent[i] < client.Sent[j] })
+	assert.Equal(t, []int64{1, 2, 3}, client.Sent)
+	assert.Len(t, client.Completed, 4)
+	assert.Empty(t, client.Aborted)
+}
+
+func TestResumeNothingToResume(t *testing.T) {
+	f, size := createTestFile(t)
+	client := &mockS3{}
+	u := Uploader{S3: client}
+	resumedPartSize, err := u.tryResume(f, size, &s3manager.UploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("dir/file"),
+	}, testPartSize)
+	assert.NoError(t, err)
+	assert.Zero(t, resumedPartSize)
+}
+
+func TestResumeAbortsNotMatchingUpload(t *testing.T) {
+	f, size := createTestFile(t)
+	client := &mockS3{
+		Uploads: []*s3.MultipartUpload{
+			{Key: aws.String("dir/file"), UploadId: aws.String("bad"), Initiated: aws.Time(time.Now())},
+		},
+		Parts: []*s3.Part{
+			{PartNumber: aws.Int64(1), Size: aws.Int64(testPartSize), ETag: aws.String(`"x"`)},
+			{PartNumber: aws.Int64(2), Size: aws.Int64(testPartSize - 1), ETag: aws.String(`"x"`)},
+		},
+	}
+	u := Uploader{S3: client}
+	resumedPartSize, err := u.tryResume(f, size, &s3manager.UploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("dir/file"),
+	}, testPartSize)
+	assert.NoError(t, err)
+	assert.Zero(t, resumedPartSize)
+	assert.Equal(t, []string{"bad"}, client.Aborted)
+	assert.Empty(t, client.Sent)
+}
+
+func TestAddFileToS3Resumes(t *testing.T) {
+	f, size := createTestFile(t)
+	client := &mockS3{
+		Uploads: []*s3.MultipartUpload{
+			{Key: aws.String("file"), UploadId: aws.String("id"), Initiated: aws.Time(time.Now())},
+		},
+	}
+	client.Parts = []*s3.Part{
+		partOf(t, f, 1, size, ""),
+		partOf(t, f, 2, size, ""),
+	}
+	// upload was created with the same headers
+	client.UploadHeads = map[string]*s3.HeadObjectOutput{
+		"id": {ContentType: aws.String("application/octet-stream")},
+	}
+	manager := &flakyS3Manager{}
+	u := Uploader{
+		Client:   manager,
+		S3:       client,
+		S3Bucket: "bucket",
+	}
+	file := walker.SrcDest{
+		SourceFile: f.Name(),
+		DstObject:  "file",
+	}
+	assert.NoError(t, u.AddFileToS3(&file))
+	assert.Equal(t, 0, manager.Calls)
+	assert.Len(t, client.Completed, 4)
+}
+
+func TestResumeModifiedFile(t *testing.T) {
+	f, size := createTestFile(t)
+	client := &mockS3{
+		// upload was started before the file was written
+		Uploads: []*s3.MultipartUpload{
+			{Key: aws.String("dir/file"), UploadId: aws.String("early"), Initiated: aws.Time(time.Now().Add(-time.Hour))},
+		},
+		Parts: []*s3.Part{partOf(t, f, 1, size, ""), partOf(t, f, 2, size, "")},
+	}
+	u := Uploader{S3: client}
+	resumedPartSize, err := u.tryResume(f, size, &s3manager.UploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("dir/file"),
+	}, testPartSize)
+	assert.NoError(t, err)
+	assert.Zero(t, resumedPartSize)
+	assert.Equal(t, []string{"early"}, client.Aborted)
+	assert.Empty(t, client.Sent)
+}
+
+func TestAddFileToS3ResumedWithStaleMetadata(t *testing.T) {
+	f, size := createTestFile(t)
+	content, err := os.ReadFile(f.Name())
+	assert.NoError(t, err)
+	sum := fmt.Sprintf("%x", sha256.Sum256(content))
+	// tail of the file changed after parts 1 and 2 were uploaded, but its
+	// modification time was kept
+	cases := []*s3.HeadObjectOutput{
+		{Metadata: map[string]*string{"Sha256": aws.String("stale")}},
+		{Metadata: map[string]*string{"Sha256": aws.String(sum)}, ContentType: aws.String("text/csv")},
+		{Metadata: map[string]*string{"Sha256": aws.String(sum)}, StorageClass: aws.String(s3.StorageClassGlacierIr)},
+	}
+	for i, head := range cases {
+		client := &mockS3{
+			Uploads: []*s3.MultipartUpload{
+				{Key: aws.String("file"), UploadId: aws.String("old"), Initiated: aws.Time(time.Now())},
+			},
+			Parts:       []*s3.Part{partOf(t, f, 1, size, ""), partOf(t, f, 2, size, "")},
+			UploadHeads: map[string]*s3.HeadObjectOutput{"old": head},
+		}
+		manager := &flakyS3Manager{}
+		u := Uploader{
+			Client:   manager,
+			S3:       client,
+			S3Bucket: "bucket",
+			Object:   ObjectOptions{ContentType: "application/octet-stream"},
+		}
+		file := walker.SrcDest{
+			SourceFile:   f.Name(),
+			SourceSha256: sum,
+			DstObject:    "file",
+		}
+		msg := fmt.Sprintf("they should be equal in iteration %d", i)
+		assert.NoError(t, u.AddFileToS3(&file), msg)
+		// resumed object is replaced by the whole file
+		assert.Len(t, client.Completed, 4, msg)
+		assert.Equal(t, 1, manager.Calls, msg)
+		assert.Equal(t, sum, aws.StringValue(manager.Inputs[0].Metadata[MetaSHA256]), msg)
+	}
+
+	// object with the same metadata and headers is kept
+	client := &mockS3{
+		Uploads: []*s3.MultipartUpload{
+			{Key: aws.String("file"), UploadId: aws.String("old"), Initiated: aws.Time(time.Now())},
+		},
+		Parts: []*s3.Part{partOf(t, f, 1, size, ""), partOf(t, f, 2, size, "")},
+		UploadHeads: map[string]*s3.HeadObjectOutput{"old": {
+			Metadata:    map[string]*string{"Sha256": aws.String(sum)},
+			ContentType: aws.String("application/octet-stream"),
+		}},
+	}
+	manager := &flakyS3Manager{}
+	u := Uploader{
+		Client:   manager,
+		S3:       client,
+		S3Bucket: "bucket",
+		Object:   ObjectOptions{ContentType: "application/octet-stream"},
+	}
+	assert.NoError(t, u.AddFileToS3(&walker.SrcDest{SourceFile: f.Name(), SourceSha256: sum, DstObject: "file"}))
+	assert.Equal(t, 0, manager.Calls)
+}
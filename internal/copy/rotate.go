@@ -0,0 +1,69 @@
+package copy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/sarunask/s3-copy/internal/envelope"
+	"github.com/sarunask/s3-copy/internal/ssec"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// RotateKey re-encrypts object file.SourceFile of SourceBucket with
+// S3SSECKey by copying it onto itself, SourceSSECKey is its current key.
+// Objects already encrypted with S3SSECKey are skipped, so interrupted
+// rotation could be started again.
+func (c *Copier) RotateKey(file *walker.SrcDest) error {
+	file.DstObject = file.SourceFile
+	attempts, class, err := c.Retry.Do(func(attempt int) error {
+		if attempt > 1 {
+			log.Infof("attempt %d to rotate key of %v", attempt, file.SourceFile)
+		}
+		rotated, err := c.rotated(file)
+		if err != nil || rotated {
+			return err
+		}
+		return c.copy(file)
+	})
+	file.Attempts = attempts
+	file.ErrorClass = string(class)
+	if err != nil {
+		return err
+	}
+	if len(file.Status) == 0 {
+		file.Status = walker.StatusRotated
+	}
+	file.KeyMD5 = ssec.Fingerprint(c.S3SSECKey)
+	return nil
+}
+
+// rotated tells if object is already encrypted with S3SSECKey. S3 refuses
+// HEAD request with a wrong SSE-C key, so it's checked with the new key.
+func (c *Copier) rotated(file *walker.SrcDest) (bool, error) {
+	head, err := c.S3.HeadObject(&s3.HeadObjectInput{
+		Bucket:               aws.String(c.SourceBucket),
+		Key:                  aws.String(file.SourceFile),
+		SSECustomerAlgorithm: aws.String(c.S3SSEC),
+		SSECustomerKey:       aws.String(c.S3SSECKey),
+	})
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) &&
+		(reqErr.StatusCode() == http.StatusBadRequest || reqErr.StatusCode() == http.StatusForbidden) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("can't get %v: %w", file.SourceFile, err)
+	}
+	log.Infof("%v is already encrypted with the new key", file.SourceFile)
+	file.SourceSize = uint64(aws.Int64Value(head.ContentLength))
+	file.SourceSha256 = envelope.MetadataValue(head.Metadata, MetaSHA256)
+	file.Status = walker.StatusSkipped
+	return true, nil
+}
@@ -0,0 +1,111 @@
+package copy
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/retry"
+	"github.com/sarunask/s3-copy/internal/ssec"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+func newRotateCopier(client *mockS3) *Copier {
+	return &Copier{
+		S3:                client,
+		SourceBucket:      "bucket",
+		DestinationBucket: "bucket",
+		S3SSEC:            "AES256",
+		SourceSSECKey:     "old",
+		S3SSECKey:         "new",
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	client := &mockS3{
+		Objects: map[string]*s3.HeadObjectOutput{
+			"dir/file": {ContentLength: aws.Int64(10)},
+		},
+		Keys: map[string]string{"dir/file": "old"},
+	}
+	file := walker.SrcDest{SourceFile: "dir/file"}
+	assert.NoError(t, newRotateCopier(client).RotateKey(&file))
+	assert.Equal(t, walker.StatusRotated, file.Status)
+	assert.Equal(t, "dir/file", file.DstObject)
+	assert.Equal(t, uint64(10), file.SourceSize)
+	assert.Equal(t, ssec.Fingerprint("new"), file.KeyMD5)
+	assert.Len(t, client.Copied, 1)
+	input := client.Copied[0]
+	assert.Equal(t, "bucket", aws.StringValue(input.Bucket))
+	assert.Equal(t, "dir/file", aws.StringValue(input.Key))
+	assert.Equal(t, "bucket/dir/file", aws.StringValue(input.CopySource))
+	assert.Equal(t, "old", aws.StringValue(input.CopySourceSSECustomerKey))
+	assert.Equal(t, "new", aws.StringValue(input.SSECustomerKey))
+}
+
+func TestRotateKeyKeepsStorageClassAndTags(t *testing.T) {
+	client := &mockS3{
+		Objects: map[string]*s3.HeadObjectOutput{
+			"dir/small": {ContentLength: aws.Int64(10), StorageClass: aws.String(s3.StorageClassDeepArchive)},
+			"dir/big":   {ContentLength: aws.Int64(MaxCopyObjectSize + 1), StorageClass: aws.String(s3.StorageClassStandardIa)},
+		},
+		Keys: map[string]string{"dir/small": "old", "dir/big": "old"},
+		Tags: map[string][]*s3.Tag{"dir/big": {{Key: aws.String("owner"), Value: aws.String("ops")}}},
+	}
+	c := newRotateCopier(client)
+	c.PartSize = MaxPartSize / 2
+	small := walker.SrcDest{SourceFile: "dir/small"}
+	assert.NoError(t, c.RotateKey(&small))
+	assert.Len(t, client.Copied, 1)
+	assert.Equal(t, s3.StorageClassDeepArchive, aws.StringValue(client.Copied[0].StorageClass))
+	assert.Equal(t, s3.TaggingDirectiveCopy, aws.StringValue(client.Copied[0].TaggingDirective))
+
+	big := walker.SrcDest{SourceFile: "dir/big"}
+	assert.NoError(t, c.RotateKey(&big))
+	assert.Len(t, client.Created, 1)
+	assert.Equal(t, s3.StorageClassStandardIa, aws.StringValue(client.Created[0].StorageClass))
+	assert.Equal(t, "owner=ops", aws.StringValue(client.Created[0].Tagging))
+	assert.Equal(t, "new", aws.StringValue(client.Created[0].SSECustomerKey))
+}
+
+func TestRotateKeySkipsRotated(t *testing.T) {
+	client := &mockS3{
+		Objects: map[string]*s3.HeadObjectOutput{
+			"dir/file": {
+				ContentLength: aws.Int64(10),
+				Metadata:      map[string]*string{"Sha256": aws.String("abc")},
+			},
+		},
+		Keys: map[string]string{"dir/file": "new"},
+	}
+	file := walker.SrcDest{SourceFile: "dir/file"}
+	assert.NoError(t, newRotateCopier(client).RotateKey(&file))
+	assert.Equal(t, walker.StatusSkipped, file.Status)
+	assert.Equal(t, "abc", file.SourceSha256)
+	assert.Equal(t, ssec.Fingerprint("new"), file.KeyMD5)
+	assert.Empty(t, client.Copied)
+}
+
+func TestRotateKeyFails(t *testing.T) {
+	client := &mockS3{
+		Objects: map[string]*s3.HeadObjectOutput{
+			"dir/file": {ContentLength: aws.Int64(10)},
+		},
+		// object is encrypted with neither old nor new key
+		Keys: map[string]string{"dir/file": "other"},
+	}
+	c := newRotateCopier(client)
+	c.Retry = retry.Policy{MaxAttempts: 3}
+	file := walker.SrcDest{SourceFile: "dir/file"}
+	assert.Error(t, c.RotateKey(&file))
+	assert.Equal(t, 1, file.Attempts)
+	assert.Equal(t, string(retry.ClassFatal), file.ErrorClass)
+	assert.Empty(t, file.Status)
+	assert.Empty(t, file.KeyMD5)
+
+	// missing objects are reported too
+	file = walker.SrcDest{SourceFile: "dir/missing"}
+	assert.ErrorContains(t, c.RotateKey(&file), "dir/missing")
+}
@@ -0,0 +1,293 @@
+package copy
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/sarunask/s3-copy/internal/envelope"
+	"github.com/sarunask/s3-copy/internal/retry"
+	"github.com/sarunask/s3-copy/internal/ssec"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// MaxCopyObjectSize is the biggest object, which could be copied with single CopyObject
+const MaxCopyObjectSize = MaxPartSize
+
+// Copier copies objects between buckets and prefixes inside S3, so their
+// content doesn't pass through this host
+type Copier struct {
+	S3                s3iface.S3API
+	SourceBucket      string
+	DestinationBucket string
+	// S3SSEC is SSE-C algorithm of both source and destination
+	S3SSEC string
+	// SourceSSECKey is SSE-C key of source objects, empty if they aren't encrypted with SSE-C
+	SourceSSECKey string
+	// S3SSECKey is SSE-C key of destination objects
+	S3SSECKey string
+	// SSE is encryption of destination objects, if they aren't SSE-C encrypted
+	SSE   SSE
+	Retry retry.Policy
+	// PartSize of objects bigger than MaxCopyObjectSize, zero means it's chosen by size
+	PartSize int64
+	// PartConcurrency is how many parts of single object are copied at once
+	PartConcurrency int
+}
+
+// copySource returns URL encoded bucket and key for CopySource parameter
+func copySource(bucket, key string) string {
+	return strings.ReplaceAll(url.PathEscape(bucket+"/"+key), "%2F", "/")
+}
+
+func (c *Copier) partConcurrency() int {
+	if c.PartConcurrency < 1 {
+		return s3manager.DefaultUploadConcurrency
+	}
+	return c.PartConcurrency
+}
+
+// CopyInS3 will copy object file.SourceFile of SourceBucket to
+// file.DstObject of DestinationBucket.
+func (c *Copier) CopyInS3(file *walker.SrcDest) error {
+	attempts, class, err := c.Retry.Do(func(attempt int) error {
+		if attempt > 1 {
+			log.Infof("attempt %d to copy %v", attempt, file.SourceFile)
+		}
+		return c.copy(file)
+	})
+	file.Attempts = attempts
+	file.ErrorClass = string(class)
+	if err == nil {
+		file.Status = walker.StatusCopied
+		file.KeyMD5 = ssec.Fingerprint(c.S3SSECKey)
+	}
+	return err
+}
+
+// copy makes single attempt to copy object
+func (c *Copier) copy(file *walker.SrcDest) error {
+	head := &s3.HeadObjectInput{
+		Bucket: aws.String(c.SourceBucket),
+		Key:    aws.String(file.SourceFile),
+	}
+	if len(c.SourceSSECKey) != 0 {
+		head.SSECustomerAlgorithm = aws.String(c.S3SSEC)
+		head.SSECustomerKey = aws.String(c.SourceSSECKey)
+	}
+	src, err := c.S3.HeadObject(head)
+	if err != nil {
+		return fmt.Errorf("can't get %v: %w", file.SourceFile, err)
+	}
+	file.SourceSize = uint64(aws.Int64Value(src.ContentLength))
+	file.SourceSha256 = envelope.MetadataValue(src.Metadata, MetaSHA256)
+	if aws.Int64Value(src.ContentLength) > MaxCopyObjectSize {
+		err = c.copyMultipart(file, src)
+	} else {
+		err = c.copyObject(file, src)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy %v to %v: %w", file.SourceFile, file.DstObject, err)
+	}
+	log.Infof("successfuly copied %v to %v", file.SourceFile, file.DstObject)
+	return nil
+}
+
+// copyObject copies object with metadata and tags in single request. Storage
+// class isn't copied, so it's taken from src.
+func (c *Copier) copyObject(file *walker.SrcDest, src *s3.HeadObjectOutput) error {
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(c.DestinationBucket),
+		Key:               aws.String(file.DstObject),
+		CopySource:        aws.String(copySource(c.SourceBucket, file.SourceFile)),
+		MetadataDirective: aws.String(s3.MetadataDirectiveCopy),
+		TaggingDirective:  aws.String(s3.TaggingDirectiveCopy),
+		StorageClass:      src.StorageClass,
+	}
+	if len(c.SourceSSECKey) != 0 {
+		input.CopySourceSSECustomerAlgorithm = aws.String(c.S3SSEC)
+		input.CopySourceSSECustomerKey = aws.String(c.SourceSSECKey)
+	}
+	if len(c.S3SSECKey) != 0 {
+		input.SSECustomerAlgorithm = aws.String(c.S3SSEC)
+		input.SSECustomerKey = aws.String(c.S3SSECKey)
+	} else {
+		sse := c.sse()
+		input.ServerSideEncryption = sse.ServerSideEncryption
+		input.SSEKMSKeyId = sse.SSEKMSKeyId
+		input.SSEKMSEncryptionContext = sse.SSEKMSEncryptionContext
+		input.BucketKeyEnabled = sse.BucketKeyEnabled
+	}
+	_, err := c.S3.CopyObject(input)
+	return err
+}
+
+// sse returns upload input with only SSE parameters of destination set
+func (c *Copier) sse() *s3manager.UploadInput {
+	input := &s3manager.UploadInput{}
+	c.SSE.apply(input)
+	return input
+}
+
+// sourceTags returns tags of source object encoded for Tagging parameter
+func (c *Copier) sourceTags(file *walker.SrcDest) (*string, error) {
+	resp, err := c.S3.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(c.SourceBucket),
+		Key:    aws.String(file.SourceFile),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get tags of %v: %w", file.SourceFile, err)
+	}
+	if len(resp.TagSet) == 0 {
+		return nil, nil
+	}
+	tags := make(map[string]string, len(resp.TagSet))
+	for _, tag := range resp.TagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return aws.String(encodeTags(tags)), nil
+}
+
+// copyMultipart copies object in ranges with UploadPartCopy. Multipart upload
+// doesn't copy metadata, storage class and tags, so they are taken from src.
+func (c *Copier) copyMultipart(file *walker.SrcDest, src *s3.HeadObjectOutput) error {
+	size := aws.Int64Value(src.ContentLength)
+	partSize, err := PartSize(size, c.PartSize)
+	if err != nil {
+		return err
+	}
+	tagging, err := c.sourceTags(file)
+	if err != nil {
+		return err
+	}
+	create := &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(c.DestinationBucket),
+		Key:                aws.String(file.DstObject),
+		CacheControl:       src.CacheControl,
+		ContentDisposition: src.ContentDisposition,
+		ContentEncoding:    src.ContentEncoding,
+		ContentLanguage:    src.ContentLanguage,
+		ContentType:        src.ContentType,
+		Metadata:           src.Metadata,
+		StorageClass:       src.StorageClass,
+		Tagging:            tagging,
+	}
+	if len(c.S3SSECKey) != 0 {
+		create.SSECustomerAlgorithm = aws.String(c.S3SSEC)
+		create.SSECustomerKey = aws.String(c.S3SSECKey)
+	} else {
+		sse := c.sse()
+		create.ServerSideEncryption = sse.ServerSideEncryption
+		create.SSEKMSKeyId = sse.SSEKMSKeyId
+		create.SSEKMSEncryptionContext = sse.SSEKMSEncryptionContext
+		create.BucketKeyEnabled = sse.BucketKeyEnabled
+	}
+	resp, err := c.S3.CreateMultipartUpload(create)
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	err = c.copyParts(file, size, partSize, resp.UploadId)
+	if err != nil {
+		// unlike uploads copies aren't resumed, so parts aren't kept
+		_, abortErr := c.S3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   create.Bucket,
+			Key:      create.Key,
+			UploadId: resp.UploadId,
+		})
+		if abortErr != nil {
+			log.Warnf("can't abort upload %s of %s: %v", aws.StringValue(resp.UploadId), file.DstObject, abortErr)
+		}
+	}
+	return err
+}
+
+// copyPart copies range of part n of source object
+func (c *Copier) copyPart(file *walker.SrcDest, size, partSize int64, uploadID *string, n int64) (*s3.CompletedPart, error) {
+	offset, length := partLayout(n, size, partSize)
+	input := &s3.UploadPartCopyInput{
+		Bucket:          aws.String(c.DestinationBucket),
+		Key:             aws.String(file.DstObject),
+		UploadId:        uploadID,
+		PartNumber:      aws.Int64(n),
+		CopySource:      aws.String(copySource(c.SourceBucket, file.SourceFile)),
+		CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	}
+	if len(c.SourceSSECKey) != 0 {
+		input.CopySourceSSECustomerAlgorithm = aws.String(c.S3SSEC)
+		input.CopySourceSSECustomerKey = aws.String(c.SourceSSECKey)
+	}
+	if len(c.S3SSECKey) != 0 {
+		input.SSECustomerAlgorithm = aws.String(c.S3SSEC)
+		input.SSECustomerKey = aws.String(c.S3SSECKey)
+	}
+	resp, err := c.S3.UploadPartCopy(input)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.CompletedPart{
+		ETag:       resp.CopyPartResult.ETag,
+		PartNumber: aws.Int64(n),
+	}, nil
+}
+
+// copyParts copies all parts with PartConcurrency and completes upload
+func (c *Copier) copyParts(file *walker.SrcDest, size, partSize int64, uploadID *string) error {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		copyErr error
+	)
+	count := partsCount(size, partSize)
+	parts := make([]*s3.CompletedPart, 0, count)
+	sem := make(chan struct{}, c.partConcurrency())
+	for n := int64(1); n <= count; n++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(n int64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			part, err := c.copyPart(file, size, partSize, uploadID, n)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if copyErr == nil {
+					copyErr = fmt.Errorf("failed to copy part %d: %w", n, err)
+				}
+				return
+			}
+			parts = append(parts, part)
+		}(n)
+	}
+	wg.Wait()
+	if copyErr != nil {
+		return copyErr
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.Int64Value(parts[i].PartNumber) < aws.Int64Value(parts[j].PartNumber)
+	})
+	input := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(c.DestinationBucket),
+		Key:             aws.String(file.DstObject),
+		UploadId:        uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}
+	if len(c.S3SSECKey) != 0 {
+		input.SSECustomerAlgorithm = aws.String(c.S3SSEC)
+		input.SSECustomerKey = aws.String(c.S3SSECKey)
+	}
+	if _, err := c.S3.CompleteMultipartUpload(input); err != nil {
+		return fmt.Errorf("failed to complete upload %s: %w", aws.StringValue(uploadID), err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package copy
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/ssec"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+func (m *mockS3) CopyObject(inp *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	m.Copied = append(m.Copied, inp)
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (m *mockS3) UploadPartCopy(inp *s3.UploadPartCopyInput) (*s3.UploadPartCopyOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.CopyErr != nil && aws.Int64Value(inp.PartNumber) == 2 {
+		return nil, m.CopyErr
+	}
+	m.PartCopy = append(m.PartCopy, inp)
+	return &s3.UploadPartCopyOutput{CopyPartResult: &s3.CopyPartResult{
+		ETag: aws.String(fmt.Sprintf(`"%d"`, aws.Int64Value(inp.PartNumber))),
+	}}, nil
+}
+
+func (m *mockS3) GetObjectTagging(inp *s3.GetObjectTaggingInput) (*s3.GetObjectTaggingOutput, error) {
+	return &s3.GetObjectTaggingOutput{TagSet: m.Tags[aws.StringValue(inp.Key)]}, nil
+}
+
+func TestCopySource(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Bucket string
+		Key    string
+		Source string
+	}{
+		{Bucket: "bucket", Key: "dir/file.txt", Source: "bucket/dir/file.txt"},
+		{Bucket: "bucket", Key: "dir/a b+c?.txt", Source: "bucket/dir/a%20b+c%3F.txt"},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.Source, copySource(c.Bucket, c.Key), fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestCopyInS3(t *testing.T) {
+	client := &mockS3{Objects: map[string]*s3.HeadObjectOutput{
+		"src/file": {
+			ContentLength: aws.Int64(10),
+			Metadata:      map[string]*string{"Sha256": aws.String("abc")},
+			StorageClass:  aws.String(s3.StorageClassStandardIa),
+		},
+	}}
+	c := Copier{
+		S3:                client,
+		SourceBucket:      "staging",
+		DestinationBucket: "production",
+		S3SSEC:            "AES256",
+		SourceSSECKey:     "old",
+		S3SSECKey:         "new",
+	}
+	file := walker.SrcDest{SourceFile: "src/file", DstObject: "dst/file"}
+	assert.NoError(t, c.CopyInS3(&file))
+	assert.Equal(t, walker.StatusCopied, file.Status)
+	assert.Equal(t, "abc", file.SourceSha256)
+	assert.Equal(t, uint64(10), file.SourceSize)
+	assert.Len(t, client.Copied, 1)
+	input := client.Copied[0]
+	assert.Equal(t, "production", aws.StringValue(input.Bucket))
+	assert.Equal(t, "dst/file", aws.StringValue(input.Key))
+	assert.Equal(t, "staging/src/file", aws.StringValue(input.CopySource))
+	assert.Equal(t, "old", aws.StringValue(input.CopySourceSSECustomerKey))
+	assert.Equal(t, "new", aws.StringValue(input.SSECustomerKey))
+	assert.Equal(t, s3.StorageClassStandardIa, aws.StringValue(input.StorageClass))
+	assert.Equal(t, s3.TaggingDirectiveCopy, aws.StringValue(input.TaggingDirective))
+	assert.Equal(t, ssec.Fingerprint("new"), file.KeyMD5)
+	assert.Empty(t, client.Created)
+}
+
+func TestCopyInS3Multipart(t *testing.T) {
+	size := MaxCopyObjectSize + 1
+	client := &mockS3{Objects: map[string]*s3.HeadObjectOutput{
+		"src/big": {
+			ContentLength: aws.Int64(size),
+			ContentType:   aws.String("application/gzip"),
+			StorageClass:  aws.String(s3.StorageClassGlacierIr),
+		},
+	}, Tags: map[string][]*s3.Tag{
+		"src/big": {
+			{Key: aws.String("team"), Value: aws.String("data eng")},
+			{Key: aws.String("env"), Value: aws.String("prod")},
+		},
+	}}
+	c := Copier{
+		S3:                client,
+		SourceBucket:      "staging",
+		DestinationBucket: "production",
+		PartSize:          MaxPartSize / 2,
+	}
+	file := walker.SrcDest{SourceFile: "src/big", DstObject: "dst/big"}
+	assert.NoError(t, c.CopyInS3(&file))
+	assert.Len(t, client.Created, 1)
+	assert.Equal(t, "application/gzip", aws.StringValue(client.Created[0].ContentType))
+	assert.Equal(t, s3.StorageClassGlacierIr, aws.StringValue(client.Created[0].StorageClass))
+	assert.Equal(t, "env=prod&team=data%20eng", aws.StringValue(client.Created[0].Tagging))
+	sort.Slice(client.PartCopy, func(i, j int) bool {
+		return aws.Int64Value(client.PartCopy[i].PartNumber) < aws.Int64Value(client.PartCopy[j].PartNumber)
+	})
+	var ranges []string
+	for _, p := range client.PartCopy {
+		ranges = append(ranges, aws.StringValue(p.CopySourceRange))
+	}
+	assert.Equal(t, []string{
+		"bytes=0-2684354559",
+		"bytes=2684354560-5368709119",
+		"bytes=5368709120-5368709120",
+	}, ranges)
+	assert.Len(t, client.Completed, 3)
+	assert.Equal(t, `"3"`, aws.StringValue(client.Completed[2].ETag))
+	assert.Empty(t, client.Aborted)
+}
+
+func TestCopyInS3AbortsFailedMultipart(t *testing.T) {
+	client := &mockS3{
+		Objects: map[string]*s3.HeadObjectOutput{
+			"src/big": {ContentLength: aws.Int64(MaxCopyObjectSize + 1)},
+		},
+		CopyErr: awserr.New("AccessDenied", "Access Denied", nil),
+	}
+	c := Copier{S3: client, SourceBucket: "staging", DestinationBucket: "production", PartSize: MaxPartSize / 2}
+	file := walker.SrcDest{SourceFile: "src/big", DstObject: "dst/big"}
+	assert.Error(t, c.CopyInS3(&file))
+	assert.Equal(t, []string{"created"}, client.Aborted)
+	assert.Empty(t, client.Completed)
+	assert.Empty(t, file.Status)
+}
@@ -0,0 +1,83 @@
+package copy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/sarunask/s3-copy/internal/envelope"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// SkipMode tells how to decide that object in S3 is the same as local file
+type SkipMode string
+
+// Skip modes
+const (
+	// SkipNever uploads every file
+	SkipNever SkipMode = "never"
+	// SkipChecksum skips files with the same SHA-256 stored in MetaSHA256
+	SkipChecksum SkipMode = "checksum"
+	// SkipSize skips files with the same size
+	SkipSize SkipMode = "size"
+	// SkipMtime skips files with the same size, which weren't modified
+	// since object was uploaded
+	SkipMtime SkipMode = "mtime"
+)
+
+// SkipModes lists all valid skip modes
+var SkipModes = []SkipMode{SkipNever, SkipChecksum, SkipSize, SkipMtime}
+
+// MetaSHA256 is user metadata key (x-amz-meta-sha256) with SHA-256 of source file
+const MetaSHA256 = "sha256"
+
+// isNotFound tells if err means that object doesn't exist
+func isNotFound(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == 404 {
+		return true
+	}
+	var aErr awserr.Error
+	return errors.As(err, &aErr) && (aErr.Code() == "NotFound" || aErr.Code() == s3.ErrCodeNoSuchKey)
+}
+
+// unchanged tells if object for file already exists in S3 and is the same
+// according to SkipExisting mode
+func (u *Uploader) unchanged(file *walker.SrcDest, size int64, input *s3manager.UploadInput) (bool, error) {
+	if u.SkipExisting == "" || u.SkipExisting == SkipNever {
+		return false, nil
+	}
+	head, err := u.S3.HeadObject(&s3.HeadObjectInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+		SSECustomerKey:       input.SSECustomerKey,
+	})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("can't check if %s exists: %w", aws.StringValue(input.Key), err)
+	}
+	mode := u.SkipExisting
+	if u.Compresses(file.SourceFile) {
+		// size of compressed object isn't known before upload
+		mode = SkipChecksum
+	}
+	switch mode {
+	case SkipChecksum:
+		sum := envelope.MetadataValue(head.Metadata, MetaSHA256)
+		return len(sum) != 0 && strings.EqualFold(sum, file.SourceSha256), nil
+	case SkipSize:
+		return aws.Int64Value(head.ContentLength) == u.ObjectSize(size), nil
+	case SkipMtime:
+		return aws.Int64Value(head.ContentLength) == u.ObjectSize(size) &&
+			!aws.TimeValue(head.LastModified).Before(file.SourceModTime), nil
+	}
+	return false, fmt.Errorf("unknown skip mode '%s'", u.SkipExisting)
+}
@@ -0,0 +1,77 @@
+package copy
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+func TestAddFileToS3SkipExisting(t *testing.T) {
+	info, err := os.Stat("./copy.go")
+	assert.NoError(t, err)
+	const sum = "6c62adc96b28bb8a141ca009f74ad345226c265806fe0eeecadcb524769f88c5"
+	client := &mockS3{
+		Objects: map[string]*s3.HeadObjectOutput{
+			"same": {
+				ContentLength: aws.Int64(info.Size()),
+				LastModified:  aws.Time(info.ModTime().Add(time.Minute)),
+				Metadata:      map[string]*string{"Sha256": aws.String(sum)},
+			},
+			"older": {
+				ContentLength: aws.Int64(info.Size()),
+				LastModified:  aws.Time(info.ModTime().Add(-time.Minute)),
+				Metadata:      map[string]*string{"Sha256": aws.String("other")},
+			},
+			"other-size": {
+				ContentLength: aws.Int64(info.Size() + 1),
+				LastModified:  aws.Time(info.ModTime().Add(time.Minute)),
+			},
+		},
+	}
+	cases := []struct {
+		Mode   SkipMode
+		Key    string
+		Status string
+	}{
+		{Mode: SkipNever, Key: "same", Status: walker.StatusUploaded},
+		{Mode: SkipChecksum, Key: "same", Status: walker.StatusSkipped},
+		{Mode: SkipChecksum, Key: "older", Status: walker.StatusUploaded},
+		{Mode: SkipChecksum, Key: "other-size", Status: walker.StatusUploaded},
+		{Mode: SkipChecksum, Key: "missing", Status: walker.StatusUploaded},
+		{Mode: SkipSize, Key: "same", Status: walker.StatusSkipped},
+		{Mode: SkipSize, Key: "older", Status: walker.StatusSkipped},
+		{Mode: SkipSize, Key: "other-size", Status: walker.StatusUploaded},
+		{Mode: SkipMtime, Key: "same", Status: walker.StatusSkipped},
+		{Mode: SkipMtime, Key: "older", Status: walker.StatusUploaded},
+		{Mode: SkipMtime, Key: "other-size", Status: walker.StatusUploaded},
+	}
+	for i, c := range cases {
+		manager := &flakyS3Manager{}
+		u := Uploader{
+			Client:       manager,
+			S3:           client,
+			S3Bucket:     "bucket",
+			SkipExisting: c.Mode,
+		}
+		file := walker.SrcDest{
+			SourceFile:    "./copy.go",
+			SourceSha256:  sum,
+			SourceModTime: info.ModTime(),
+			DstObject:     c.Key,
+		}
+		assert.NoError(t, u.AddFileToS3(&file), fmt.Sprintf("iteration %d", i))
+		assert.Equal(t, c.Status, file.Status, fmt.Sprintf("they should be equal in iteration %d", i))
+		if c.Status == walker.StatusSkipped {
+			assert.Equal(t, 0, manager.Calls, fmt.Sprintf("iteration %d", i))
+		} else {
+			assert.Equal(t, 1, manager.Calls, fmt.Sprintf("iteration %d", i))
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package copy
+
+import (
+	"encoding/base64"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// SSE is server-side encryption with S3 or KMS managed keys. Unlike SSE-C
+// keys aren't sent with requests, so it's a separate mode.
+type SSE struct {
+	// Algorithm is AES256, aws:kms or aws:kms:dsse, empty means bucket default
+	Algorithm string
+	// KMSKeyID is CMK of aws:kms, empty means AWS managed key
+	KMSKeyID string
+	// KMSContext is JSON encryption context of aws:kms
+	KMSContext       string
+	BucketKeyEnabled bool
+}
+
+// isKMS tells if objects are encrypted with KMS keys
+func (s SSE) isKMS() bool {
+	return s.Algorithm == s3.ServerSideEncryptionAwsKms || s.Algorithm == walker.SSEKMSDSSE
+}
+
+// withRow returns encryption replaced by one of CSV row. KMS context and
+// bucket key are kept only when row uses the same algorithm.
+func (s SSE) withRow(row *walker.Options) SSE {
+	if row == nil || len(row.SSE) == 0 {
+		return s
+	}
+	if row.SSE != s.Algorithm {
+		return SSE{Algorithm: row.SSE, KMSKeyID: row.SSEKMSKeyID}
+	}
+	s.KMSKeyID = row.SSEKMSKeyID
+	return s
+}
+
+// apply sets encryption parameters of s on input
+func (s SSE) apply(input *s3manager.UploadInput) {
+	if len(s.Algorithm) == 0 {
+		return
+	}
+	input.ServerSideEncryption = aws.String(s.Algorithm)
+	if len(s.KMSKeyID) != 0 {
+		input.SSEKMSKeyId = aws.String(s.KMSKeyID)
+	}
+	if len(s.KMSContext) != 0 {
+		input.SSEKMSEncryptionContext = aws.String(base64.StdEncoding.EncodeToString([]byte(s.KMSContext)))
+	}
+	if s.BucketKeyEnabled {
+		input.BucketKeyEnabled = aws.Bool(true)
+	}
+}
+
+// etagIsMD5 tells if ETag of object or part encrypted with sse algorithm or
+// SSE-C customer algorithm is MD5 of its content. It isn't for SSE-C and
+// SSE-KMS encrypted objects.
+func etagIsMD5(sse, customerAlgorithm *string) bool {
+	if customerAlgorithm != nil {
+		return false
+	}
+	alg := aws.StringValue(sse)
+	return alg != s3.ServerSideEncryptionAwsKms && alg != walker.SSEKMSDSSE
+}
+
+// uploadSSE returns encryption algorithm of objects uploaded with input.
+// Without one in input it's default encryption of bucket, which is unknown
+// when it can't be read.
+func (u *Uploader) uploadSSE(input *s3manager.UploadInput) *string {
+	if input.ServerSideEncryption != nil || input.SSECustomerAlgorithm != nil {
+		return input.ServerSideEncryption
+	}
+	resp, err := u.S3.GetBucketEncryption(&s3.GetBucketEncryptionInput{Bucket: input.Bucket})
+	if err != nil {
+		log.Debugf("can't get default encryption of bucket %s: %v", aws.StringValue(input.Bucket), err)
+		return nil
+	}
+	if resp.ServerSideEncryptionConfiguration == nil {
+		return nil
+	}
+	for _, rule := range resp.ServerSideEncryptionConfiguration.Rules {
+		if rule.ApplyServerSideEncryptionByDefault != nil {
+			return rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package copy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+func TestSSEApply(t *testing.T) {
+	t.Parallel()
+
+	input := &s3manager.UploadInput{}
+	SSE{
+		Algorithm:        s3.ServerSideEncryptionAwsKms,
+		KMSKeyID:         "arn:aws:kms:eu-west-1:111122223333:key/abc",
+		KMSContext:       `{"project":"x"}`,
+		BucketKeyEnabled: true,
+	}.apply(input)
+	assert.Equal(t, "aws:kms", aws.StringValue(input.ServerSideEncryption))
+	assert.Equal(t, "arn:aws:kms:eu-west-1:111122223333:key/abc", aws.StringValue(input.SSEKMSKeyId))
+	assert.Equal(t, "eyJwcm9qZWN0IjoieCJ9", aws.StringValue(input.SSEKMSEncryptionContext))
+	assert.True(t, aws.BoolValue(input.BucketKeyEnabled))
+
+	input = &s3manager.UploadInput{}
+	SSE{}.apply(input)
+	assert.Equal(t, &s3manager.UploadInput{}, input)
+}
+
+func TestSSEWithRow(t *testing.T) {
+	t.Parallel()
+
+	global := SSE{Algorithm: s3.ServerSideEncryptionAwsKms, KMSKeyID: "key-id", KMSContext: `{"project":"x"}`, BucketKeyEnabled: true}
+	cases := []struct {
+		Row *walker.Options
+		SSE SSE
+	}{
+		{Row: nil, SSE: global},
+		{Row: &walker.Options{}, SSE: global},
+		{
+			Row: &walker.Options{SSE: "aws:kms", SSEKMSKeyID: "other-id"},
+			SSE: SSE{Algorithm: "aws:kms", KMSKeyID: "other-id", KMSContext: `{"project":"x"}`, BucketKeyEnabled: true},
+		},
+		{Row: &walker.Options{SSE: "aws:kms"}, SSE: SSE{Algorithm: "aws:kms", KMSContext: `{"project":"x"}`, BucketKeyEnabled: true}},
+		{Row: &walker.Options{SSE: "AES256"}, SSE: SSE{Algorithm: "AES256"}},
+		{Row: &walker.Options{SSE: walker.SSEKMSDSSE, SSEKMSKeyID: "other-id"}, SSE: SSE{Algorithm: walker.SSEKMSDSSE, KMSKeyID: "other-id"}},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.SSE, global.withRow(c.Row), fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestEtagIsMD5(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		SSE               *string
+		CustomerAlgorithm *string
+		MD5               bool
+	}{
+		{MD5: true},
+		{SSE: aws.String(s3.ServerSideEncryptionAes256), MD5: true},
+		{SSE: aws.String(s3.ServerSideEncryptionAwsKms)},
+		{SSE: aws.String(walker.SSEKMSDSSE)},
+		{CustomerAlgorithm: aws.String("AES256")},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.MD5, etagIsMD5(c.SSE, c.CustomerAlgorithm), fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestUploadSSE(t *testing.T) {
+	t.Parallel()
+
+	u := Uploader{S3: &mockS3{BucketSSE: s3.ServerSideEncryptionAwsKms}}
+	// encryption of upload wins over default encryption of bucket
+	assert.Equal(t, "AES256", aws.StringValue(u.uploadSSE(&s3manager.UploadInput{ServerSideEncryption: aws.String("AES256")})))
+	assert.Nil(t, u.uploadSSE(&s3manager.UploadInput{SSECustomerAlgorithm: aws.String("AES256")}))
+	assert.Equal(t, "aws:kms", aws.StringValue(u.uploadSSE(&s3manager.UploadInput{Bucket: aws.String("bucket")})))
+	// bucket without default encryption
+	u = Uploader{S3: &mockS3{}}
+	assert.Nil(t, u.uploadSSE(&s3manager.UploadInput{Bucket: aws.String("bucket")}))
+}
+
+func TestAddFileToS3WithSSE(t *testing.T) {
+	client := &flakyS3Manager{}
+	u := Uploader{
+		Client:   client,
+		S3Bucket: "bucket",
+		SSE:      SSE{Algorithm: s3.ServerSideEncryptionAwsKms, KMSKeyID: "key-id"},
+	}
+	assert.NoError(t, u.AddFileToS3(&walker.SrcDest{SourceFile: "./copy.go", DstObject: "a"}))
+	// SSE-C key of CSV row wins over SSE
+	assert.NoError(t, u.AddFileToS3(&walker.SrcDest{
+		SourceFile: "./copy.go",
+		DstObject:  "b",
+		Options:    &walker.Options{SSECKey: "01234567890123456789012345678901"},
+	}))
+	assert.Equal(t, "aws:kms", aws.StringValue(client.Inputs[0].ServerSideEncryption))
+	assert.Equal(t, "key-id", aws.StringValue(client.Inputs[0].SSEKMSKeyId))
+	assert.Nil(t, client.Inputs[1].ServerSideEncryption)
+	assert.Nil(t, client.Inputs[1].SSEKMSKeyId)
+
+	// SSE of CSV row wins over global SSE-C key
+	u = Uploader{
+		Client:    client,
+		S3Bucket:  "bucket",
+		S3SSEC:    "AES256",
+		S3SSECKey: "01234567890123456789012345678901",
+	}
+	file := walker.SrcDest{
+		SourceFile: "./copy.go",
+		DstObject:  "c",
+		Options:    &walker.Options{SSE: s3.ServerSideEncryptionAwsKms, SSEKMSKeyID: "row-key-id"},
+	}
+	assert.NoError(t, u.AddFileToS3(&file))
+	assert.Equal(t, "aws:kms", aws.StringValue(client.Inputs[2].ServerSideEncryption))
+	assert.Equal(t, "row-key-id", aws.StringValue(client.Inputs[2].SSEKMSKeyId))
+	assert.Nil(t, client.Inputs[2].SSECustomerKey)
+	assert.Empty(t, file.KeyMD5)
+}
+
+func TestVerifyWithSSEKMS(t *testing.T) {
+	f, size := createTestFile(t)
+	content, err := os.ReadFile(f.Name())
+	assert.NoError(t, err)
+	// ETag of SSE-KMS object isn't MD5, so object is downloaded and hashed
+	client := &mockS3{
+		Objects: map[string]*s3.HeadObjectOutput{
+			"file": {
+				ContentLength:        aws.Int64(size),
+				ETag:                 aws.String(`"0123456789abcdef0123456789abcdef"`),
+				ServerSideEncryption: aws.String(s3.ServerSideEncryptionAwsKms),
+			},
+		},
+		Bodies: map[string][]byte{"file": content},
+	}
+	u := Uploader{
+		S3:       client,
+		S3Bucket: "bucket",
+		SSE:      SSE{Algorithm: s3.ServerSideEncryptionAwsKms},
+	}
+	file := walker.SrcDest{SourceFile: f.Name(), SourceSha256: fmt.Sprintf("%x", sha256.Sum256(content)), DstObject: "file"}
+	assert.NoError(t, u.verify(&file, size, DefaultPartSize))
+	file.SourceSha256 = "def"
+	assert.ErrorIs(t, u.verify(&file, size, DefaultPartSize), errMismatch)
+
+	// encryption is taken from object, as bucket could encrypt it by default
+	u.SSE = SSE{}
+	file.SourceSha256 = fmt.Sprintf("%x", sha256.Sum256(content))
+	assert.NoError(t, u.verify(&file, size, DefaultPartSize))
+}
+
+func TestCopyInS3WithSSE(t *testing.T) {
+	client := &mockS3{Objects: map[string]*s3.HeadObjectOutput{"src": {ContentLength: aws.Int64(1)}}}
+	c := Copier{
+		S3:                client,
+		SourceBucket:      "staging",
+		DestinationBucket: "production",
+		SSE:               SSE{Algorithm: s3.ServerSideEncryptionAwsKms, BucketKeyEnabled: true},
+	}
+	assert.NoError(t, c.CopyInS3(&walker.SrcDest{SourceFile: "src", DstObject: "dst"}))
+	assert.Equal(t, "aws:kms", aws.StringValue(client.Copied[0].ServerSideEncryption))
+	assert.True(t, aws.BoolValue(client.Copied[0].BucketKeyEnabled))
+}
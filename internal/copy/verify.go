@@ -0,0 +1,196 @@
+package copy
+
+import (
+	"crypto/md5" // nolint:gosec
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/sarunask/s3-copy/internal/envelope"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// errMismatch is returned when uploaded object differs from local file
+var errMismatch = errors.New("uploaded object doesn't match local file")
+
+// multipartETag returns ETag S3 gives to object uploaded from r in parts of
+// partSize. Single part object has MD5 of its content, multipart object has
+// MD5 of concatenated MD5s of parts followed by number of parts.
+func multipartETag(r io.ReaderAt, size, partSize int64) (string, error) {
+	if size <= partSize {
+		h := md5.New() // nolint:gosec
+		if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%x", h.Sum(nil)), nil
+	}
+	parts := md5.New() // nolint:gosec
+	count := partsCount(size, partSize)
+	for n := int64(1); n <= count; n++ {
+		h := md5.New() // nolint:gosec
+		offset, length := partLayout(n, size, partSize)
+		if _, err := io.Copy(h, io.NewSectionReader(r, offset, length)); err != nil {
+			return "", err
+		}
+		parts.Write(h.Sum(nil))
+	}
+	return fmt.Sprintf("%x-%d", parts.Sum(nil), count), nil
+}
+
+// compositeChecksum returns additional checksum S3 gives to object uploaded
+// from r in parts of partSize. Multipart object has checksum of concatenated
+// checksums of parts followed by number of parts.
+func compositeChecksum(alg string, r io.ReaderAt, size, partSize int64) (string, error) {
+	if size <= partSize {
+		return checksumOf(alg, io.NewSectionReader(r, 0, size))
+	}
+	parts, err := newChecksumHash(alg)
+	if err != nil {
+		return "", err
+	}
+	count := partsCount(size, partSize)
+	for n := int64(1); n <= count; n++ {
+		h, _ := newChecksumHash(alg)
+		offset, length := partLayout(n, size, partSize)
+		if _, err := io.Copy(h, io.NewSectionReader(r, offset, length)); err != nil {
+			return "", err
+		}
+		parts.Write(h.Sum(nil))
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(parts.Sum(nil)), count), nil
+}
+
+// headChecksum returns checksum of object for alg or empty string if it has none
+func headChecksum(head *s3.HeadObjectOutput, alg string) string {
+	switch alg {
+	case s3.ChecksumAlgorithmCrc32:
+		return aws.StringValue(head.ChecksumCRC32)
+	case s3.ChecksumAlgorithmCrc32c:
+		return aws.StringValue(head.ChecksumCRC32C)
+	case s3.ChecksumAlgorithmSha1:
+		return aws.StringValue(head.ChecksumSHA1)
+	case s3.ChecksumAlgorithmSha256:
+		return aws.StringValue(head.ChecksumSHA256)
+	}
+	return ""
+}
+
+// objectSha256 downloads object, which head describes, and returns SHA-256
+// of its content. Client-side encrypted content is decrypted first.
+func (u *Uploader) objectSha256(head *s3.HeadObjectInput, etag *string) (string, error) {
+	resp, err := u.S3.GetObjectWithContext(aws.BackgroundContext(), &s3.GetObjectInput{
+		Bucket:               head.Bucket,
+		Key:                  head.Key,
+		IfMatch:              etag,
+		SSECustomerAlgorithm: head.SSECustomerAlgorithm,
+		SSECustomerKey:       head.SSECustomerKey,
+	}, func(r *request.Request) {
+		// compressed object is hashed as it's stored, transport shouldn't decompress it
+		r.HTTPRequest.Header.Set("Accept-Encoding", "identity")
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't download %s: %w", aws.StringValue(head.Key), err)
+	}
+	defer resp.Body.Close()
+	h := sha256.New()
+	if u.Encryption != nil {
+		if _, err := u.Encryption.Decrypt(h, resp.Body, resp.Metadata); err != nil {
+			// content, which can't be authenticated, isn't what was uploaded
+			return "", fmt.Errorf("%w: can't decrypt %s: %v", errMismatch, aws.StringValue(head.Key), err)
+		}
+	} else {
+		_, err = io.Copy(h, resp.Body)
+	}
+	if err != nil {
+		return "", fmt.Errorf("can't read %s: %w", aws.StringValue(head.Key), err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// verify checks that object uploaded from file in parts of partSize has the
+// same size and content. ETag is compared when it's MD5 based, which isn't
+// the case for objects S3 reports as SSE-C or SSE-KMS encrypted, also by
+// default encryption of bucket, then additional checksum is used. Content
+// of objects without either of them, like compressed or encrypted on client
+// side, is downloaded and hashed.
+func (u *Uploader) verify(file *walker.SrcDest, size, partSize int64) error {
+	input := u.uploadInput(file)
+	key := aws.StringValue(input.Key)
+	params := &s3.HeadObjectInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+		SSECustomerKey:       input.SSECustomerKey,
+	}
+	if input.ChecksumAlgorithm != nil {
+		params.ChecksumMode = aws.String(s3.ChecksumModeEnabled)
+	}
+	head, err := u.S3.HeadObject(params)
+	if err != nil {
+		return fmt.Errorf("can't verify %s: %w", key, err)
+	}
+	expected := u.ObjectSize(size)
+	if u.Compresses(file.SourceFile) {
+		expected = int64(file.CompressedSize)
+	}
+	if aws.Int64Value(head.ContentLength) != expected {
+		return fmt.Errorf("%w: %s has %d bytes instead of %d",
+			errMismatch, key, aws.Int64Value(head.ContentLength), expected)
+	}
+	f, err := os.Open(file.SourceFile)
+	if err != nil {
+		return fmt.Errorf("failed to open file %v: %w", file.SourceFile, err)
+	}
+	defer f.Close()
+	switch {
+	case u.Encryption != nil || u.Compresses(file.SourceFile):
+		if u.Encryption != nil && !envelope.IsEncrypted(head.Metadata) {
+			return fmt.Errorf("%w: %s isn't encrypted", errMismatch, key)
+		}
+		want := file.SourceSha256
+		if u.Compresses(file.SourceFile) {
+			want = file.CompressedSha256
+		}
+		got, err := u.objectSha256(params, head.ETag)
+		if err != nil {
+			return fmt.Errorf("can't verify %s: %w", key, err)
+		}
+		if !strings.EqualFold(got, want) {
+			return fmt.Errorf("%w: %s has SHA-256 '%s' instead of '%s'", errMismatch, key, got, want)
+		}
+	case etagIsMD5(head.ServerSideEncryption, head.SSECustomerAlgorithm):
+		etag, err := multipartETag(f, size, partSize)
+		if err != nil {
+			return fmt.Errorf("can't calculate ETag of %v: %w", file.SourceFile, err)
+		}
+		if got := strings.Trim(aws.StringValue(head.ETag), `"`); got != etag {
+			return fmt.Errorf("%w: %s has ETag %s instead of %s", errMismatch, key, got, etag)
+		}
+	case input.ChecksumAlgorithm != nil:
+		alg := aws.StringValue(input.ChecksumAlgorithm)
+		sum, err := compositeChecksum(alg, f, size, partSize)
+		if err != nil {
+			return fmt.Errorf("can't calculate checksum of %v: %w", file.SourceFile, err)
+		}
+		if got := headChecksum(head, alg); got != sum {
+			return fmt.Errorf("%w: %s has %s checksum '%s' instead of '%s'", errMismatch, key, alg, got, sum)
+		}
+	default:
+		got, err := u.objectSha256(params, head.ETag)
+		if err != nil {
+			return fmt.Errorf("can't verify %s: %w", key, err)
+		}
+		if !strings.EqualFold(got, file.SourceSha256) {
+			return fmt.Errorf("%w: %s has SHA-256 '%s' instead of '%s'", errMismatch, key, got, file.SourceSha256)
+		}
+	}
+	return nil
+}
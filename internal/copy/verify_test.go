@@ -0,0 +1,125 @@
+package copy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/retry"
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+func TestMultipartETag(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		PartSize int64
+		ETag     string
+	}{
+		{PartSize: 9, ETag: "25f9e794323b453885f5181f1b624d0b"},
+		{PartSize: 100, ETag: "25f9e794323b453885f5181f1b624d0b"},
+		{PartSize: 4, ETag: "393e928fcf5925fcbd3a06aaf20b2d38-3"},
+	}
+	for i, c := range cases {
+		etag, err := multipartETag(strings.NewReader("123456789"), 9, c.PartSize)
+		assert.NoError(t, err)
+		assert.Equal(t, c.ETag, etag, fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestCompositeChecksum(t *testing.T) {
+	t.Parallel()
+
+	sum, err := compositeChecksum(s3.ChecksumAlgorithmSha256, strings.NewReader("123456789"), 9, 9)
+	assert.NoError(t, err)
+	assert.Equal(t, "FeKw08M4keuw8e9gnsQZQgwg4yDOlMZfvIwzEkSOsiU=", sum)
+	sum, err = compositeChecksum(s3.ChecksumAlgorithmSha256, strings.NewReader("123456789"), 9, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "RWtJBRAdYQ9Y6rETLya5JMkap8fADJo5biSsdBWQ50E=-3", sum)
+}
+
+func TestAddFileToS3Verify(t *testing.T) {
+	f, size := createTestFile(t)
+	etag, err := multipartETag(f, size, DefaultPartSize)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(f.Name())
+	assert.NoError(t, err)
+	sum := fmt.Sprintf("%x", sha256.Sum256(content))
+	changed := append([]byte{}, content...)
+	changed[0]++
+
+	cases := []struct {
+		Head       *s3.HeadObjectOutput
+		Body       []byte
+		SSECKey    string
+		ErrorClass retry.Class
+	}{
+		{
+			Head: &s3.HeadObjectOutput{ContentLength: aws.Int64(size), ETag: aws.String(`"` + etag + `"`)},
+		},
+		{
+			Head:       &s3.HeadObjectOutput{ContentLength: aws.Int64(size - 1), ETag: aws.String(`"` + etag + `"`)},
+			ErrorClass: retry.ClassMismatch,
+		},
+		{
+			Head:       &s3.HeadObjectOutput{ContentLength: aws.Int64(size), ETag: aws.String(`"0123456789abcdef0123456789abcdef"`)},
+			ErrorClass: retry.ClassMismatch,
+		},
+		{
+			// ETag isn't MD5 with SSE-C, so object is downloaded and hashed
+			Head: &s3.HeadObjectOutput{
+				ContentLength:        aws.Int64(size),
+				ETag:                 aws.String(`"0123456789abcdef0123456789abcdef"`),
+				Metadata:             map[string]*string{"Sha256": aws.String(sum)},
+				SSECustomerAlgorithm: aws.String("AES256"),
+			},
+			Body:    content,
+			SSECKey: "key",
+		},
+		{
+			// stored SHA-256 isn't trusted, as it was written by uploader
+			Head: &s3.HeadObjectOutput{
+				ContentLength:        aws.Int64(size),
+				Metadata:             map[string]*string{"Sha256": aws.String(sum)},
+				SSECustomerAlgorithm: aws.String("AES256"),
+			},
+			Body:       changed,
+			SSECKey:    "key",
+			ErrorClass: retry.ClassMismatch,
+		},
+		{
+			ErrorClass: retry.ClassFatal,
+		},
+	}
+	for i, c := range cases {
+		client := &mockS3{Objects: map[string]*s3.HeadObjectOutput{}, Bodies: map[string][]byte{}}
+		if c.Head != nil {
+			client.Objects["file"] = c.Head
+		}
+		if c.Body != nil {
+			client.Bodies["file"] = c.Body
+		}
+		u := Uploader{
+			Client:    &flakyS3Manager{},
+			S3:        client,
+			S3Bucket:  "bucket",
+			S3SSEC:    "AES256",
+			S3SSECKey: c.SSECKey,
+			Verify:    true,
+		}
+		file := walker.SrcDest{
+			SourceFile:   f.Name(),
+			SourceSha256: sum,
+			DstObject:    "file",
+		}
+		err := u.AddFileToS3(&file)
+		assert.Equal(t, c.ErrorClass != retry.ClassNone, err != nil, fmt.Sprintf("they should be equal in iteration %d", i))
+		assert.Equal(t, string(c.ErrorClass), file.ErrorClass, fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
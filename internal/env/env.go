@@ -1,23 +1,64 @@
 package env
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/araddon/dateparse"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/spf13/pflag"
+
+	"github.com/sarunask/s3-copy/internal/copy"
+	"github.com/sarunask/s3-copy/internal/listing"
+	"github.com/sarunask/s3-copy/internal/ssec"
+	"github.com/sarunask/s3-copy/internal/units"
+	"github.com/sarunask/s3-copy/internal/walker"
 )
 
 const shortTimeForm = time.RFC3339 // "2001-Jan-24 01:45"
 
+// Commands which could be given as first argument
+const (
+	CommandUpload    = "upload"
+	CommandMultipart = "multipart"
+	CommandDownload  = "download"
+	CommandCopy      = "copy"
+	CommandSync      = "sync"
+	CommandRotateKey = "rotate-key"
+)
+
+// Commands lists all commands
+var Commands = []string{CommandUpload, CommandSync, CommandDownload, CommandCopy, CommandRotateKey, CommandMultipart}
+
+// s3URLScheme starts source and destination of copy command
+const s3URLScheme = "s3://"
+
 // MaxWorkersCount notes how many workers we should have sending to S3
 const MaxWorkersCount = 100
 
+// MaxPartConcurrency notes how many parts of single file could be sent at once
+const MaxPartConcurrency = 100
+
+// partSizeAuto lets part size to be chosen by file size
+const partSizeAuto = "auto"
+
+// Server-side encryption modes
+const (
+	sseNone    = "none"
+	sseAES256  = "AES256"
+	sseKMS     = "aws:kms"
+	sseKMSDSSE = walker.SSEKMSDSSE
+)
+
 func (c *Config) validatePath() {
 	var err error
 	c.Path = filepath.ToSlash(filepath.Clean(c.Path))
@@ -27,22 +68,333 @@ func (c *Config) validatePath() {
 	}
 }
 
-func (c *Config) validateKeyAndAlg() {
-	if len(c.S3SSECKey) != 0 && len(c.S3SSECKey) != 32 {
-		log.Fatalf("S3 Customer Key must be 32 bytes long and not %d.",
-			len(c.S3SSECKey))
+// validateKeyAndAlgAndAdd loads SSE-C keys of destination and source from
+// flags, files, environment variables or stdin and decodes them
+func (c *Config) validateKeyAndAlgAndAdd(key, sourceKey ssec.Source, encoding string) {
+	if !contains(ssec.Encodings, encoding) {
+		log.Fatalf("sse-c-key-encoding should be one of: %s", strings.Join(ssec.Encodings, ", "))
+	}
+	if key.Stdin && sourceKey.Stdin {
+		log.Fatalf("only one of sse-c-key-stdin and source-sse-c-key-stdin could be used")
+	}
+	var err error
+	c.SSECKeyEncoding = encoding
+	c.S3SSECKey, err = ssec.Load(key, encoding, os.Stdin)
+	if err != nil {
+		log.Fatalf("bad S3 Customer Key: %v", err)
+	}
+	c.SourceSSECKey, err = ssec.Load(sourceKey, encoding, os.Stdin)
+	if err != nil {
+		log.Fatalf("bad S3 Customer Key of source: %v", err)
 	}
 	if c.S3SSEC != "AES256" {
 		log.Fatalf("S3 Customer algorithm must be AES256.")
 	}
 }
 
+func (c *Config) validateSSE() {
+	switch c.SSE {
+	case sseNone:
+		c.SSE = ""
+	case sseAES256, sseKMS, sseKMSDSSE:
+	default:
+		log.Fatalf("sse should be one of: %s, %s, %s, %s", sseNone, sseAES256, sseKMS, sseKMSDSSE)
+	}
+	kms := c.SSE == sseKMS || c.SSE == sseKMSDSSE
+	switch {
+	case len(c.SSE) != 0 && len(c.S3SSECKey) != 0:
+		log.Fatalf("sse %s can't be used together with sse-c-key", c.SSE)
+	case !kms && len(c.SSEKMSKeyID) != 0:
+		log.Fatalf("sse-kms-key-id needs sse %s or %s", sseKMS, sseKMSDSSE)
+	case !kms && len(c.SSEKMSContext) != 0:
+		log.Fatalf("sse-kms-context needs sse %s or %s", sseKMS, sseKMSDSSE)
+	case c.BucketKeyEnabled && c.SSE != sseKMS:
+		log.Fatalf("bucket-key-enabled needs sse %s", sseKMS)
+	}
+	if len(c.SSEKMSContext) != 0 {
+		var context map[string]string
+		if err := json.Unmarshal([]byte(c.SSEKMSContext), &context); err != nil {
+			log.Fatalf("sse-kms-context should be JSON object with string values: %v", err)
+		}
+	}
+}
+
 func (c *Config) validateWorkersCount() {
 	if c.WorkersCount < 1 || c.WorkersCount > MaxWorkersCount {
 		log.Fatalf("Workers should be in this range [1,100]")
 	}
 }
 
+func (c *Config) validateCommand(args []string) {
+	if len(args) == 0 {
+		c.Command = CommandUpload
+		return
+	}
+	if args[0] == CommandCopy {
+		c.Command = CommandCopy
+		c.validateCopyArgs(args[1:])
+		return
+	}
+	if len(args) > 1 {
+		log.Fatalf("only one command is allowed and not %v", args)
+	}
+	for _, command := range Commands {
+		if args[0] == command {
+			c.Command = command
+			return
+		}
+	}
+	log.Fatalf("unknown command '%s', should be one of: %s",
+		args[0], strings.Join(Commands, ", "))
+}
+
+// parseS3URL splits s3://bucket/prefix into bucket and prefix
+func parseS3URL(s3URL string) (string, string) {
+	if !strings.HasPrefix(s3URL, s3URLScheme) {
+		log.Fatalf("'%s' should start with %s", s3URL, s3URLScheme)
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(s3URL, s3URLScheme), "/")
+	if len(bucket) == 0 {
+		log.Fatalf("'%s' has no bucket", s3URL)
+	}
+	return bucket, prefix
+}
+
+func (c *Config) validateCopyArgs(args []string) {
+	if len(args) != 2 {
+		log.Fatalf("copy command needs source and destination like s3://src-bucket/prefix s3://dst-bucket/prefix and not %v", args)
+	}
+	c.SourceBucket, c.SourcePrefix = parseS3URL(args[0])
+	c.DestinationBucket, c.DestinationPrefix = parseS3URL(args[1])
+	if c.SourceBucket == c.DestinationBucket && c.SourcePrefix == c.DestinationPrefix {
+		log.Fatalf("source and destination of copy command should differ")
+	}
+}
+
+func (c *Config) validateKeyRegex(keyRegex string) {
+	if len(keyRegex) == 0 {
+		return
+	}
+	re, err := regexp.Compile(keyRegex)
+	if err != nil {
+		log.Fatalf("bad key-regex pattern '%s': %v", keyRegex, err)
+	}
+	c.KeyRegex = re
+}
+
+func (c *Config) validateOlderThan() {
+	if c.OlderThan < 0 {
+		log.Fatalf("older-than must not be negative")
+	}
+}
+
+func (c *Config) validateRetry() {
+	if c.RetryMaxAttempts < 1 {
+		log.Fatalf("retry-max-attempts must be at least 1 and not %d", c.RetryMaxAttempts)
+	}
+	if c.RetryBaseDelay <= 0 || c.RetryMaxDelay < c.RetryBaseDelay {
+		log.Fatalf("retry-base-delay must be positive and not bigger than retry-max-delay")
+	}
+	if c.RetryJitter < 0 || c.RetryJitter > 1 {
+		log.Fatalf("retry-jitter should be in this range [0,1]")
+	}
+}
+
+func (c *Config) validatePartSizeAndAdd(partSize string) {
+	if partSize == partSizeAuto {
+		return
+	}
+	size, err := units.ParseBytes(partSize)
+	if err != nil {
+		log.Fatalf("part-size should be '%s' or size like '64MiB': %v", partSizeAuto, err)
+	}
+	if size < 5*units.MiB || size > 5*units.GiB {
+		log.Fatalf("part-size should be in this range [5MiB,5GiB] and not %d", size)
+	}
+	c.PartSize = size
+}
+
+func (c *Config) validateAfterUploadAndAdd(afterUpload string) {
+	action, dir, _ := strings.Cut(afterUpload, ":")
+	switch {
+	case (action == "keep" || action == "delete") && afterUpload == action:
+	case action == "move" && len(dir) != 0:
+		c.AfterUploadDir = filepath.Clean(dir)
+	default:
+		log.Fatalf("after-upload should be keep, delete or move:<dir> and not '%s'", afterUpload)
+	}
+	c.AfterUpload = action
+	switch {
+	case action == "keep":
+	case c.Command == CommandSync:
+		// next sync --delete would delete objects of files, which are gone
+		log.Fatalf("after-upload %s can't be used with %s command", action, CommandSync)
+	case action == "move" && len(c.InputCSVFile) == 0 &&
+		listing.Contained(c.Path, filepath.Join(c.AfterUploadDir, "file")) == nil:
+		log.Fatalf("after-upload directory %s shouldn't be inside path %s, as moved files would be uploaded again",
+			c.AfterUploadDir, c.Path)
+	}
+}
+
+func (c *Config) validateArchiveAndAdd(threshold, shardSize string) {
+	if len(threshold) == 0 {
+		return
+	}
+	var err error
+	if c.ArchiveThreshold, err = units.ParseBytes(threshold); err != nil || c.ArchiveThreshold < 1 {
+		log.Fatalf("archive-threshold should be positive size like '1MiB' and not '%s'", threshold)
+	}
+	if c.ArchiveShardSize, err = units.ParseBytes(shardSize); err != nil {
+		log.Fatalf("archive-shard-size should be size like '1GiB': %v", err)
+	}
+	switch {
+	case c.ArchiveShardSize < c.ArchiveThreshold:
+		log.Fatalf("archive-shard-size should be at least archive-threshold")
+	case c.Command != CommandUpload:
+		log.Fatalf("archive-threshold can be used only with %s command", CommandUpload)
+	case len(c.CSEKey) != 0 || len(c.Compress) != 0:
+		log.Fatalf("archive-threshold can't be used with client-side encryption or compress, as index has offsets of plain files")
+	case c.AfterUpload != "keep":
+		log.Fatalf("archive-threshold can't be used with after-upload %s", c.AfterUpload)
+	}
+}
+
+// validateRotateKey checks that rotate-key command has both keys and they differ
+func (c *Config) validateRotateKey() {
+	if c.Command != CommandRotateKey {
+		return
+	}
+	if len(c.SourceSSECKey) == 0 || len(c.S3SSECKey) == 0 {
+		log.Fatalf("%s command needs the current key as source-sse-c-key and the new one as sse-c-key", CommandRotateKey)
+	}
+	if c.SourceSSECKey == c.S3SSECKey {
+		log.Fatalf("the new key of %s command should differ from the current one", CommandRotateKey)
+	}
+	if len(c.SSE) != 0 {
+		log.Fatalf("sse can't be used with %s command", CommandRotateKey)
+	}
+}
+
+// validateCopyEncryption keeps SSE-C encryption of copied objects, unless
+// other encryption of destination is chosen, so they aren't silently left
+// with bucket default encryption
+func (c *Config) validateCopyEncryption() {
+	if c.Command != CommandCopy || len(c.SourceSSECKey) == 0 || len(c.S3SSECKey) != 0 {
+		return
+	}
+	if len(c.SSE) == 0 {
+		log.Infof("sse-c-key isn't set, destination objects are encrypted with source-sse-c-key")
+		c.S3SSECKey = c.SourceSSECKey
+	}
+}
+
+func (c *Config) validateMaxDelete() {
+	if c.MaxDelete < 0 {
+		log.Fatalf("max-delete should not be negative")
+	}
+}
+
+func (c *Config) validatePartConcurrency() {
+	if c.PartConcurrency < 1 || c.PartConcurrency > MaxPartConcurrency {
+		log.Fatalf("part-concurrency should be in this range [1,%d]", MaxPartConcurrency)
+	}
+}
+
+func (c *Config) validateBandwidthAndAdd(maxBandwidth, maxBandwidthBurst, maxHashBandwidth string) {
+	parse := func(name, value string, parser func(string) (int64, error)) int64 {
+		if len(value) == 0 {
+			return 0
+		}
+		v, err := parser(value)
+		if err != nil {
+			log.Fatalf("bad %s: %v", name, err)
+		}
+		if v == 0 {
+			log.Fatalf("%s must be positive", name)
+		}
+		return v
+	}
+	c.MaxBandwidth = parse("max-bandwidth", maxBandwidth, units.ParseRate)
+	c.MaxBandwidthBurst = parse("max-bandwidth-burst", maxBandwidthBurst, units.ParseBytes)
+	c.MaxHashBandwidth = parse("max-hash-bandwidth", maxHashBandwidth, units.ParseRate)
+	if c.MaxBandwidthBurst != 0 && c.MaxBandwidth == 0 {
+		log.Fatalf("max-bandwidth-burst requires max-bandwidth")
+	}
+}
+
+func (c *Config) validateSkipExisting() {
+	modes := make([]string, 0, len(copy.SkipModes))
+	for _, mode := range copy.SkipModes {
+		modes = append(modes, string(mode))
+	}
+	if !contains(modes, c.SkipExisting) {
+		log.Fatalf("skip-existing should be one of: %s", strings.Join(modes, ", "))
+	}
+}
+
+// validateCSEAndAdd loads master key of client-side encryption
+func (c *Config) validateCSEAndAdd(key ssec.Source, encoding string) {
+	if !contains(ssec.Encodings, encoding) {
+		log.Fatalf("cse-key-encoding should be one of: %s", strings.Join(ssec.Encodings, ", "))
+	}
+	var err error
+	c.CSEKey, err = ssec.Load(key, encoding, os.Stdin)
+	if err != nil {
+		log.Fatalf("bad client-side encryption key: %v", err)
+	}
+	if len(c.CSEKey) != 0 && len(c.ChecksumAlgorithm) != 0 {
+		log.Fatalf("checksum-algorithm can't be used with client-side encryption, as checksums are calculated before upload")
+	}
+}
+
+// compileRegexp returns compiled expr of flag or nil if expr is empty
+func compileRegexp(flag, expr string) *regexp.Regexp {
+	if len(expr) == 0 {
+		return nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		log.Fatalf("bad %s regexp '%s': %v", flag, expr, err)
+	}
+	return re
+}
+
+func (c *Config) validateCompressAndAdd(compress, include, exclude string) {
+	switch compress {
+	case copy.CompressNone:
+		return
+	case copy.CompressGzip:
+	default:
+		log.Fatalf("compress should be one of: %s, %s", copy.CompressNone, copy.CompressGzip)
+	}
+	switch {
+	case c.CompressLevel < gzip.BestSpeed || c.CompressLevel > gzip.BestCompression:
+		log.Fatalf("compress-level should be in this range [%d,%d]", gzip.BestSpeed, gzip.BestCompression)
+	case len(c.CSEKey) != 0:
+		log.Fatalf("compress can't be used with client-side encryption")
+	case len(c.ChecksumAlgorithm) != 0:
+		log.Fatalf("checksum-algorithm can't be used with compress, as checksums are calculated before upload")
+	case len(c.ContentEncoding) != 0:
+		log.Fatalf("content-encoding can't be used with compress, it's set to %s", compress)
+	}
+	c.Compress = compress
+	c.CompressInclude = compileRegexp("compress-include", include)
+	c.CompressExclude = compileRegexp("compress-exclude", exclude)
+}
+
+func (c *Config) validateChecksumAlgorithmAndAdd(alg string) {
+	if strings.EqualFold(alg, "none") {
+		return
+	}
+	for _, valid := range s3.ChecksumAlgorithm_Values() {
+		if strings.EqualFold(alg, valid) {
+			c.ChecksumAlgorithm = valid
+			return
+		}
+	}
+	log.Fatalf("checksum-algorithm should be one of: none, %s", strings.Join(s3.ChecksumAlgorithm_Values(), ", "))
+}
+
 func (c *Config) validateExcludes() {
 	for _, exclude := range *c.Exclude {
 		_, err := regexp.Compile(exclude)
@@ -66,12 +418,148 @@ func (c *Config) validateNewerThanAndAdd(newerThan *string) {
 	c.NewerThan = timeNewerThan
 }
 
+func (c *Config) validateExpiresAndAdd(expires string) {
+	if len(expires) == 0 {
+		return
+	}
+	t, err := dateparse.ParseAny(expires)
+	if err != nil {
+		log.Fatalf("Correct format for expires is '%s': %v",
+			shortTimeForm, err)
+	}
+	c.Expires = t
+}
+
+func (c *Config) validateContentTypeMap() {
+	if len(c.ContentTypeMap) == 0 {
+		return
+	}
+	if _, err := os.Stat(c.ContentTypeMap); err != nil {
+		log.Fatalf("content-type-map '%s' is not valid file: %v", c.ContentTypeMap, err)
+	}
+}
+
+// validateEndpoint checks options of S3 compatible storage. Endpoint without
+// scheme uses HTTPS, unless disable-ssl is set.
+func (c *Config) validateEndpoint() {
+	if len(c.EndpointURL) != 0 {
+		endpoint := c.EndpointURL
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+		u, err := url.Parse(endpoint)
+		switch {
+		case err != nil:
+			log.Fatalf("endpoint-url '%s' is not valid: %v", c.EndpointURL, err)
+		case u.Scheme != "http" && u.Scheme != "https":
+			log.Fatalf("endpoint-url scheme should be http or https and not '%s'", u.Scheme)
+		case len(u.Host) == 0:
+			log.Fatalf("endpoint-url '%s' has no host", c.EndpointURL)
+		case c.DisableSSL && strings.HasPrefix(c.EndpointURL, "https://"):
+			log.Fatalf("disable-ssl can't be used with https endpoint-url")
+		}
+	}
+	if len(c.CABundle) == 0 {
+		return
+	}
+	if c.DisableSSL {
+		log.Fatalf("ca-bundle can't be used together with disable-ssl")
+	}
+	if _, err := os.Stat(c.CABundle); err != nil {
+		log.Fatalf("ca-bundle '%s' is not valid file: %v", c.CABundle, err)
+	}
+}
+
+// Limits of assumed role sessions
+const (
+	minRoleDuration = 15 * time.Minute
+	maxRoleDuration = 12 * time.Hour
+)
+
+var roleSessionNameRegexp = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// validateRole checks options of role to assume. MFA token is read from
+// stdin, so it can't be used when keys are read from stdin too.
+func (c *Config) validateRole(keyStdin bool) {
+	if len(c.RoleARN) == 0 {
+		switch {
+		case len(c.ExternalID) != 0:
+			log.Fatalf("external-id needs role-arn")
+		case len(c.RoleSessionName) != 0:
+			log.Fatalf("role-session-name needs role-arn")
+		case len(c.MFASerial) != 0:
+			log.Fatalf("mfa-serial needs role-arn")
+		}
+	} else if !strings.HasPrefix(c.RoleARN, "arn:") {
+		log.Fatalf("role-arn '%s' is not valid ARN", c.RoleARN)
+	}
+	if len(c.RoleSessionName) != 0 && !roleSessionNameRegexp.MatchString(c.RoleSessionName) {
+		log.Fatalf("role-session-name should be 2 to 64 letters, digits or +=,.@_- and not '%s'", c.RoleSessionName)
+	}
+	if c.RoleDuration != 0 && (c.RoleDuration < minRoleDuration || c.RoleDuration > maxRoleDuration) {
+		log.Fatalf("role-duration should be between %v and %v and not %v", minRoleDuration, maxRoleDuration, c.RoleDuration)
+	}
+	if len(c.MFASerial) != 0 && keyStdin {
+		log.Fatalf("mfa-serial can't be used together with keys read from stdin")
+	}
+}
+
+// parseKeyValues returns map of key=value pairs given to flag name
+func parseKeyValues(name string, pairs []string) map[string]string {
+	result := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || len(k) == 0 {
+			log.Fatalf("%s should be key=value and not '%s'", name, pair)
+		}
+		if _, ok := result[k]; ok {
+			log.Fatalf("%s key '%s' is given more than once", name, k)
+		}
+		result[k] = v
+	}
+	return result
+}
+
+func (c *Config) validateStorageClassAndACL() {
+	if len(c.StorageClass) != 0 && !contains(s3.ObjectStorageClass_Values(), c.StorageClass) {
+		log.Fatalf("storage-class should be one of: %s", strings.Join(s3.ObjectStorageClass_Values(), ", "))
+	}
+	if len(c.ACL) != 0 && !contains(s3.ObjectCannedACL_Values(), c.ACL) {
+		log.Fatalf("acl should be one of: %s", strings.Join(s3.ObjectCannedACL_Values(), ", "))
+	}
+}
+
+func (c *Config) validateTagsAndAdd(tags []string) {
+	c.Tags = parseKeyValues("tag", tags)
+	if err := walker.ValidateTags(c.Tags); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+func (c *Config) validateMetadataAndAdd(metadata []string) {
+	c.Metadata = parseKeyValues("metadata", metadata)
+	if err := walker.ValidateMetadata(c.Metadata, len(c.CSEKey) != 0); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 // Config is configuration which would be used in our project
 type Config struct {
+	Command           string
 	S3Bucket          string
 	S3Region          string
 	S3SSEC            string
 	S3SSECKey         string
+	SSECKeyEncoding   string
 	InputCSVFile      string
 	OutputSuccessFile string
 	OutputFailureFile string
@@ -82,6 +570,74 @@ type Config struct {
 	DryRun            bool
 	DebugHTTP         bool
 	NewerThan         time.Time
+	RetryMaxAttempts  int
+	RetryBaseDelay    time.Duration
+	RetryMaxDelay     time.Duration
+	RetryJitter       float64
+	Prefix            string
+	OlderThan         time.Duration
+	KeyRegex          *regexp.Regexp
+	PartSize          int64
+	PartConcurrency   int
+	MaxBandwidth      int64
+	MaxBandwidthBurst int64
+	MaxHashBandwidth  int64
+	SkipExisting      string
+	ChecksumAlgorithm string
+	Verify            bool
+	// SourceBucket, SourcePrefix, DestinationBucket and DestinationPrefix
+	// are set by copy command arguments
+	SourceBucket       string
+	SourcePrefix       string
+	DestinationBucket  string
+	DestinationPrefix  string
+	SourceSSECKey      string
+	Delete             bool
+	MaxDelete          int
+	AfterUpload        string
+	AfterUploadDir     string
+	ContentTypeMap     string
+	ContentDisposition string
+	CacheControl       string
+	ContentEncoding    string
+	ContentLanguage    string
+	Expires            time.Time
+	StorageClass       string
+	ACL                string
+	Tags               map[string]string
+	Metadata           map[string]string
+	SSE                string
+	SSEKMSKeyID        string
+	SSEKMSContext      string
+	BucketKeyEnabled   bool
+	// CSEKey is master key of client-side encryption, empty if it's disabled
+	CSEKey string
+	// Compress is compression algorithm, empty if files aren't compressed
+	Compress        string
+	CompressLevel   int
+	CompressInclude *regexp.Regexp
+	CompressExclude *regexp.Regexp
+	CompressSuffix  bool
+	// ArchiveThreshold is size of files, smaller ones are packed into tar
+	// shards of ArchiveShardSize, zero if files aren't packed
+	ArchiveThreshold int64
+	ArchiveShardSize int64
+	ArchivePrefix    string
+	// EndpointURL, ForcePathStyle, DisableSSL and CABundle are used to
+	// work with S3 compatible storage like MinIO or Ceph RGW
+	EndpointURL    string
+	ForcePathStyle bool
+	DisableSSL     bool
+	CABundle       string
+	// Profile of shared config, default credential chain is used if empty
+	Profile string
+	// RoleARN is role assumed with credentials of Profile or default chain,
+	// empty if role isn't assumed
+	RoleARN         string
+	ExternalID      string
+	RoleSessionName string
+	RoleDuration    time.Duration
+	MFASerial       string
 }
 
 // Settings holds all settings we have in our app
@@ -89,45 +645,174 @@ var Settings *Config
 
 func init() {
 	sseC := pflag.String("sse-c", "AES256", "encryption type to be used in S3")
-	sseCKey := pflag.String("sse-c-key", "", "encryption key to be used in S3")
+	sseCKey := pflag.String("sse-c-key", "", "encryption key to be used in S3, it's visible in shell history and process list, so prefer sse-c-key-file, sse-c-key-env or sse-c-key-stdin")
+	sseCKeyFile := pflag.String("sse-c-key-file", "", "File with encryption key to be used in S3")
+	sseCKeyEnv := pflag.String("sse-c-key-env", "", "Environment variable with encryption key to be used in S3")
+	sseCKeyStdin := pflag.Bool("sse-c-key-stdin", false, "Read encryption key to be used in S3 from stdin")
+	sseCKeyEncoding := pflag.String("sse-c-key-encoding", ssec.EncodingRaw, "Encoding of SSE-C keys: raw, base64 or hex")
 	s3Region := pflag.String("s3-region", "eu-west-1", "S3 region")
-	inputCSVFile := pflag.String("input-csv", "", "CSV file, which contains: source,s3_destination_path. Source can be relative. Destination will be relative to S3 bucket.")
+	endpointURL := pflag.String("endpoint-url", "", "URL of S3 compatible storage, e.g. 'https://minio.local:9000'. AWS S3 by default")
+	forcePathStyle := pflag.Bool("force-path-style", false, "Put bucket into URL path instead of host name, most S3 compatible storages need it")
+	disableSSL := pflag.Bool("disable-ssl", false, "Use HTTP instead of HTTPS")
+	profile := pflag.String("profile", "", "Profile of AWS shared config and credentials files, default credential chain if empty")
+	roleARN := pflag.String("role-arn", "", "ARN of IAM role to assume with credentials of profile or default chain. Its credentials are refreshed before they expire")
+	externalID := pflag.String("external-id", "", "External ID of role to assume")
+	roleSessionName := pflag.String("role-session-name", "", "Session name of assumed role, e.g. CI job ID. Generated if empty")
+	roleDuration := pflag.Duration("role-duration", time.Hour, "Duration of assumed role sessions, from 15m up to maximum session duration of role")
+	mfaSerial := pflag.String("mfa-serial", "", "Serial number or ARN of MFA device needed by role, token is read from stdin on every refresh")
+	caBundle := pflag.String("ca-bundle", "", "PEM file with CA certificates, which are trusted instead of system ones, e.g. for self-signed endpoint certificate")
+	inputCSVFile := pflag.String("input-csv", "", "CSV file, which contains: source,s3_destination_path. Source can be relative. Destination will be relative to S3 bucket. For download command it contains: s3_key,local_path")
 	outSuccessFile := pflag.String("out-success", "success.csv", "CSV file, which will have successfully uploaded files")
 	outFailureFile := pflag.String("out-failure", "failure.csv", "CSV file, which will have failed uploaded files")
 	exclude := pflag.StringArray("exclude", nil, "which files to exclude (Regexp match, doesn't work if you provide CSV file to upload)")
 	s3bucket := pflag.String("s3-bucket", "", "S3 bucket where to upload")
-	path := pflag.String("path", ".", "From which path to copy")
+	path := pflag.String("path", ".", "From which path to copy or to which directory to download")
 	debug := pflag.Bool("debug", false, "Enable debugging")
 	debugHTTP := pflag.Bool("debug-http", false, "Enable debugging for HTTP requests")
 	workers := pflag.Int("workers", 5, "Number of workers")
 	dryRun := pflag.Bool("dry-run", false, "Enable dry run - no upload")
 	newerThan := pflag.String("newer-than", "", fmt.Sprintf("Include files with modification time newer than time you provided. Example time format is '%s'.",
 		shortTimeForm))
+	retryMaxAttempts := pflag.Int("retry-max-attempts", 5, "How many times to try uploading a file before reporting it as failed")
+	retryBaseDelay := pflag.Duration("retry-base-delay", time.Second, "Delay before second attempt, it's doubled after each failed attempt")
+	retryMaxDelay := pflag.Duration("retry-max-delay", 30*time.Second, "Maximum delay between attempts")
+	retryJitter := pflag.Float64("retry-jitter", 0.5, "Part of delay in range [0,1] which is randomized")
+	prefix := pflag.String("prefix", "", "S3 key prefix to work on (multipart, download, sync and rotate-key commands)")
+	olderThan := pflag.Duration("older-than", 0, "Abort multipart uploads started earlier than that, e.g. 72h (multipart command)")
+	keyRegex := pflag.String("key-regex", "", "Abort multipart uploads with keys matching this regexp (multipart command)")
+	partSize := pflag.String("part-size", partSizeAuto, "Part size of multipart uploads, e.g. '64MiB'. With 'auto' it's 10MiB, but grows so any file up to 5TiB fits into 10000 parts")
+	partConcurrency := pflag.Int("part-concurrency", 5, "Number of parts of a single file uploaded at once")
+	maxBandwidth := pflag.String("max-bandwidth", "", "Limit upload speed of all workers together, e.g. '50MiB/s'. Unlimited by default")
+	maxBandwidthBurst := pflag.String("max-bandwidth-burst", "", "How much could be read at once over max-bandwidth, e.g. '8MiB'. By default it's one second of max-bandwidth")
+	maxHashBandwidth := pflag.String("max-hash-bandwidth", "", "Limit how fast files are read to calculate their SHA-256, e.g. '200MiB/s'. Unlimited by default")
+	skipExisting := pflag.String("skip-existing", string(copy.SkipNever), "Skip files already in S3: 'checksum' compares SHA-256 stored in x-amz-meta-sha256, 'size' compares size, 'mtime' compares size and if file wasn't modified after upload, 'never' uploads everything")
+	checksumAlgorithm := pflag.String("checksum-algorithm", "none", "S3 additional checksum to send with uploads, so S3 checks integrity end to end: none, SHA256, CRC32C, CRC32 or SHA1")
+	sourceSSECKey := pflag.String("source-sse-c-key", "", "encryption key of source objects (copy command) or current key (rotate-key command), by default they aren't SSE-C encrypted")
+	sourceSSECKeyFile := pflag.String("source-sse-c-key-file", "", "File with encryption key of source objects (copy command)")
+	sourceSSECKeyEnv := pflag.String("source-sse-c-key-env", "", "Environment variable with encryption key of source objects (copy command)")
+	sourceSSECKeyStdin := pflag.Bool("source-sse-c-key-stdin", false, "Read encryption key of source objects from stdin (copy command)")
+	deleteExtraneous := pflag.Bool("delete", false, "Delete objects under prefix, which have no local file (sync command)")
+	maxDelete := pflag.Int("max-delete", 100, "Don't delete anything if more objects than that would be deleted (sync command)")
+	afterUpload := pflag.String("after-upload", "keep", "What to do with local file after it's uploaded and verified: keep, delete or move:<dir>")
+	contentTypeMap := pflag.String("content-type-map", "", "CSV file with extension,content-type records, which override Content-Type detected by extension or content")
+	contentDisposition := pflag.String("content-disposition", "", "Content-Disposition of uploaded objects")
+	cacheControl := pflag.String("cache-control", "", "Cache-Control of uploaded objects, e.g. 'max-age=3600'")
+	contentEncoding := pflag.String("content-encoding", "", "Content-Encoding of uploaded objects")
+	contentLanguage := pflag.String("content-language", "", "Content-Language of uploaded objects")
+	expires := pflag.String("expires", "", fmt.Sprintf("Expires of uploaded objects. Example time format is '%s'.", shortTimeForm))
+	storageClass := pflag.String("storage-class", "", fmt.Sprintf("Storage class of uploaded objects: %s. Bucket default if empty",
+		strings.Join(s3.ObjectStorageClass_Values(), ", ")))
+	acl := pflag.String("acl", "", fmt.Sprintf("Canned ACL of uploaded objects: %s", strings.Join(s3.ObjectCannedACL_Values(), ", ")))
+	tags := pflag.StringArray("tag", nil, "Tag of uploaded objects as key=value, could be repeated")
+	metadata := pflag.StringArray("metadata", nil, "User metadata (x-amz-meta-*) of uploaded objects as key=value, could be repeated")
+	sse := pflag.String("sse", sseNone, "Server-side encryption with S3 or KMS managed keys: none, AES256, aws:kms or aws:kms:dsse. It can't be used with sse-c-key")
+	sseKMSKeyID := pflag.String("sse-kms-key-id", "", "KMS key ID or ARN for aws:kms encryption, AWS managed key if empty")
+	sseKMSContext := pflag.String("sse-kms-context", "", `KMS encryption context for aws:kms encryption as JSON object, e.g. '{"project":"x"}'`)
+	bucketKeyEnabled := pflag.Bool("bucket-key-enabled", false, "Use S3 Bucket Key for aws:kms encryption")
+	cseKeyFile := pflag.String("cse-key-file", "", "File with master key of client-side encryption: files are encrypted before upload and decrypted after download")
+	cseKeyEnv := pflag.String("cse-key-env", "", "Environment variable with master key of client-side encryption")
+	cseKeyEncoding := pflag.String("cse-key-encoding", ssec.EncodingRaw, "Encoding of client-side encryption key: raw, base64 or hex")
+	compress := pflag.String("compress", copy.CompressNone, "Compress files before upload and set Content-Encoding: none or gzip")
+	compressLevel := pflag.Int("compress-level", 6, "gzip compression level from 1 (fastest) to 9 (smallest)")
+	compressInclude := pflag.String("compress-include", "", "Compress only files with paths matching this regexp, all files by default")
+	compressExclude := pflag.String("compress-exclude", "", "Don't compress files with paths matching this regexp, e.g. '\\.(gz|zip|jpg)$'")
+	compressSuffix := pflag.Bool("compress-suffix", false, "Add .gz to keys of compressed objects")
+	archiveThreshold := pflag.String("archive-threshold", "", "Pack files smaller than that, e.g. '1MiB', into tar shards with index (upload command). Files aren't packed by default")
+	archiveShardSize := pflag.String("archive-shard-size", "1GiB", "Target size of tar shards")
+	archivePrefix := pflag.String("archive-prefix", "archive/", "Key prefix of tar shards and their index")
+	verify := pflag.Bool("verify", false, "Check every uploaded object with HEAD request: its size and ETag or checksum should match local file")
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [%s] [flags]\n", os.Args[0], strings.Join(Commands, "|"))
+		fmt.Fprintf(os.Stderr, "       %s %s s3://src-bucket/prefix s3://dst-bucket/prefix [flags]\n", os.Args[0], CommandCopy)
+		pflag.PrintDefaults()
+	}
 	pflag.Parse()
-	if len(*s3bucket) == 0 {
+	// copy command takes buckets from its arguments
+	if len(*s3bucket) == 0 && pflag.Arg(0) != CommandCopy {
 		fmt.Println("Not enough parameters")
-		pflag.PrintDefaults()
+		pflag.Usage()
 		os.Exit(1)
 	}
 	Settings = &Config{
-		S3Bucket:          *s3bucket,
-		S3Region:          *s3Region,
-		S3SSEC:            *sseC,
-		S3SSECKey:         *sseCKey,
-		InputCSVFile:      *inputCSVFile,
-		OutputSuccessFile: *outSuccessFile,
-		OutputFailureFile: *outFailureFile,
-		Exclude:           exclude,
-		Path:              *path,
-		Debug:             *debug,
-		DebugHTTP:         *debugHTTP,
-		WorkersCount:      *workers,
-		DryRun:            *dryRun,
-		NewerThan:         time.Time{},
+		S3Bucket:           *s3bucket,
+		S3Region:           *s3Region,
+		EndpointURL:        *endpointURL,
+		ForcePathStyle:     *forcePathStyle,
+		DisableSSL:         *disableSSL,
+		CABundle:           *caBundle,
+		Profile:            *profile,
+		RoleARN:            *roleARN,
+		ExternalID:         *externalID,
+		RoleSessionName:    *roleSessionName,
+		RoleDuration:       *roleDuration,
+		MFASerial:          *mfaSerial,
+		S3SSEC:             *sseC,
+		InputCSVFile:       *inputCSVFile,
+		OutputSuccessFile:  *outSuccessFile,
+		OutputFailureFile:  *outFailureFile,
+		Exclude:            exclude,
+		Path:               *path,
+		Debug:              *debug,
+		DebugHTTP:          *debugHTTP,
+		WorkersCount:       *workers,
+		DryRun:             *dryRun,
+		NewerThan:          time.Time{},
+		RetryMaxAttempts:   *retryMaxAttempts,
+		RetryBaseDelay:     *retryBaseDelay,
+		RetryMaxDelay:      *retryMaxDelay,
+		RetryJitter:        *retryJitter,
+		Prefix:             *prefix,
+		OlderThan:          *olderThan,
+		PartConcurrency:    *partConcurrency,
+		SkipExisting:       *skipExisting,
+		Verify:             *verify,
+		Delete:             *deleteExtraneous,
+		MaxDelete:          *maxDelete,
+		ContentTypeMap:     *contentTypeMap,
+		ContentDisposition: *contentDisposition,
+		CacheControl:       *cacheControl,
+		ContentEncoding:    *contentEncoding,
+		ContentLanguage:    *contentLanguage,
+		StorageClass:       *storageClass,
+		ACL:                *acl,
+		SSE:                *sse,
+		SSEKMSKeyID:        *sseKMSKeyID,
+		SSEKMSContext:      *sseKMSContext,
+		BucketKeyEnabled:   *bucketKeyEnabled,
+		CompressLevel:      *compressLevel,
+		CompressSuffix:     *compressSuffix,
+		ArchivePrefix:      *archivePrefix,
 	}
+	Settings.validateCommand(pflag.Args())
 	Settings.validatePath()
-	Settings.validateKeyAndAlg()
+	Settings.validateEndpoint()
+	Settings.validateRole(*sseCKeyStdin || *sourceSSECKeyStdin)
+	Settings.validateKeyAndAlgAndAdd(
+		ssec.Source{Value: *sseCKey, File: *sseCKeyFile, Env: *sseCKeyEnv, Stdin: *sseCKeyStdin},
+		ssec.Source{Value: *sourceSSECKey, File: *sourceSSECKeyFile, Env: *sourceSSECKeyEnv, Stdin: *sourceSSECKeyStdin},
+		*sseCKeyEncoding)
+	Settings.validateSSE()
+	Settings.validateRotateKey()
+	Settings.validateCopyEncryption()
 	Settings.validateWorkersCount()
+	Settings.validateRetry()
+	Settings.validateSkipExisting()
+	Settings.validateChecksumAlgorithmAndAdd(*checksumAlgorithm)
+	Settings.validateCSEAndAdd(ssec.Source{File: *cseKeyFile, Env: *cseKeyEnv}, *cseKeyEncoding)
+	Settings.validateCompressAndAdd(*compress, *compressInclude, *compressExclude)
 	Settings.validateExcludes()
 	Settings.validateNewerThanAndAdd(newerThan)
+	Settings.validatePartSizeAndAdd(*partSize)
+	Settings.validatePartConcurrency()
+	Settings.validateMaxDelete()
+	Settings.validateAfterUploadAndAdd(*afterUpload)
+	Settings.validateArchiveAndAdd(*archiveThreshold, *archiveShardSize)
+	Settings.validateExpiresAndAdd(*expires)
+	Settings.validateContentTypeMap()
+	Settings.validateStorageClassAndACL()
+	Settings.validateTagsAndAdd(*tags)
+	Settings.validateMetadataAndAdd(*metadata)
+	Settings.validateBandwidthAndAdd(*maxBandwidth, *maxBandwidthBurst, *maxHashBandwidth)
+	Settings.validateOlderThan()
+	Settings.validateKeyRegex(*keyRegex)
 }
@@ -0,0 +1,257 @@
+package envelope
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Algorithm of object content: AES-256-GCM over ChunkSize chunks, so
+// objects of any size could be encrypted and decrypted as stream
+const Algorithm = "AES256-GCM-CHUNKED"
+
+// ChunkSize is size of plaintext sealed at once
+const ChunkSize = 64 * 1024
+
+// KeySize is size of master and data keys
+const KeySize = 32
+
+// ContentType of encrypted objects
+const ContentType = "application/octet-stream"
+
+// User metadata keys (x-amz-meta-*) of encrypted objects
+const (
+	MetaPrefix    = "cse-"
+	MetaAlgorithm = MetaPrefix + "alg"
+	MetaKey       = MetaPrefix + "key"
+	MetaIV        = MetaPrefix + "iv"
+)
+
+// MetadataSize is size of metadata keys and values added to encrypted
+// objects: base64 of wrapped key has nonce, key and tag and base64 of IV
+// has 12 bytes
+const MetadataSize = len(MetaAlgorithm) + len(Algorithm) + len(MetaKey) + (12+KeySize+16+2)/3*4 +
+	len(MetaIV) + 12/3*4
+
+// additional data of the last chunk, so truncated object doesn't decrypt
+var (
+	adChunk     = []byte{0}
+	adLastChunk = []byte{1}
+)
+
+// Key is master key, which wraps random data key of every object
+type Key struct {
+	aead cipher.AEAD
+}
+
+// newGCM returns AES-256-GCM with key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes long and not %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewKey returns master key of raw key bytes
+func NewKey(master string) (*Key, error) {
+	aead, err := newGCM([]byte(master))
+	if err != nil {
+		return nil, fmt.Errorf("bad master key: %w", err)
+	}
+	return &Key{aead: aead}, nil
+}
+
+// EncryptedSize returns size of object with size bytes of plaintext
+func EncryptedSize(size int64) int64 {
+	chunks := (size + ChunkSize - 1) / ChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return size + chunks*16
+}
+
+// IsEncrypted tells if object with metadata was encrypted by Key.Encrypt
+func IsEncrypted(metadata map[string]*string) bool {
+	return len(MetadataValue(metadata, MetaAlgorithm)) != 0
+}
+
+// MetadataValue returns value of user metadata key. S3 returns keys in
+// canonical header form, so they are compared ignoring case.
+func MetadataValue(metadata map[string]*string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) && v != nil {
+			return *v
+		}
+	}
+	return ""
+}
+
+// Encrypt returns reader of r encrypted with new data key and metadata,
+// which should be stored with the object
+func (k *Key) Encrypt(r io.Reader) (io.Reader, map[string]string, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, fmt.Errorf("can't generate data key: %w", err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	iv := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, nil, fmt.Errorf("can't generate IV: %w", err)
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, fmt.Errorf("can't generate nonce: %w", err)
+	}
+	wrapped := k.aead.Seal(nonce, nonce, dataKey, []byte(Algorithm))
+	metadata := map[string]string{
+		MetaAlgorithm: Algorithm,
+		MetaKey:       base64.StdEncoding.EncodeToString(wrapped),
+		MetaIV:        base64.StdEncoding.EncodeToString(iv),
+	}
+	return &encryptReader{
+		src:   bufio.NewReaderSize(r, ChunkSize),
+		aead:  aead,
+		iv:    iv,
+		plain: make([]byte, ChunkSize),
+	}, metadata, nil
+}
+
+// unwrap returns data key and IV of object with metadata
+func (k *Key) unwrap(metadata map[string]*string) ([]byte, []byte, error) {
+	if alg := MetadataValue(metadata, MetaAlgorithm); alg != Algorithm {
+		return nil, nil, fmt.Errorf("unknown encryption algorithm '%s'", alg)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(MetadataValue(metadata, MetaKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad wrapped key: %w", err)
+	}
+	iv, err := base64.StdEncoding.DecodeString(MetadataValue(metadata, MetaIV))
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad IV: %w", err)
+	}
+	size := k.aead.NonceSize()
+	if len(wrapped) < size {
+		return nil, nil, fmt.Errorf("wrapped key is too short")
+	}
+	dataKey, err := k.aead.Open(nil, wrapped[:size], wrapped[size:], []byte(Algorithm))
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't unwrap data key, master key could be wrong: %w", err)
+	}
+	return dataKey, iv, nil
+}
+
+// Decrypt writes content of object r with metadata decrypted to w and
+// returns how many bytes were written
+func (k *Key) Decrypt(w io.Writer, r io.Reader, metadata map[string]*string) (int64, error) {
+	dataKey, iv, err := k.unwrap(metadata)
+	if err != nil {
+		return 0, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return 0, err
+	}
+	if len(iv) != aead.NonceSize() {
+		return 0, fmt.Errorf("IV must be %d bytes long and not %d", aead.NonceSize(), len(iv))
+	}
+	src := bufio.NewReaderSize(r, ChunkSize+aead.Overhead())
+	sealed := make([]byte, ChunkSize+aead.Overhead())
+	var written int64
+	for i := uint64(0); ; i++ {
+		n, err := io.ReadFull(src, sealed)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return written, err
+		}
+		last := err != nil || isEOF(src)
+		ad := adChunk
+		if last {
+			ad = adLastChunk
+		}
+		plain, err := aead.Open(sealed[:0], chunkNonce(iv, i), sealed[:n], ad)
+		if err != nil {
+			return written, fmt.Errorf("chunk %d can't be decrypted, object is damaged or truncated: %w", i, err)
+		}
+		m, err := w.Write(plain)
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+		if last {
+			return written, nil
+		}
+	}
+}
+
+// isEOF tells if nothing is left in r
+func isEOF(r *bufio.Reader) bool {
+	_, err := r.Peek(1)
+	return errors.Is(err, io.EOF)
+}
+
+// chunkNonce returns nonce of chunk i, which is IV with i added to its last
+// 8 bytes, so every chunk has its own nonce
+func chunkNonce(iv []byte, i uint64) []byte {
+	nonce := make([]byte, len(iv))
+	copy(nonce, iv)
+	tail := nonce[len(nonce)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^i)
+	return nonce
+}
+
+// encryptReader seals chunks of src as they are read
+type encryptReader struct {
+	src   *bufio.Reader
+	aead  cipher.AEAD
+	iv    []byte
+	plain []byte
+	buf   []byte
+	// sealed is part of the current chunk, which wasn't read yet
+	sealed []byte
+	chunk  uint64
+	done   bool
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	if len(e.sealed) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		if err := e.seal(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, e.sealed)
+	e.sealed = e.sealed[n:]
+	return n, nil
+}
+
+// seal reads and encrypts next chunk of src
+func (e *encryptReader) seal() error {
+	n, err := io.ReadFull(e.src, e.plain)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	ad := adChunk
+	if err != nil || isEOF(e.src) {
+		ad = adLastChunk
+		e.done = true
+	}
+	e.buf = e.aead.Seal(e.buf[:0], chunkNonce(e.iv, e.chunk), e.plain[:n], ad)
+	e.sealed = e.buf
+	e.chunk++
+	return nil
+}
@@ -0,0 +1,102 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMaster = "01234567890123456789012345678901"
+
+// encrypt returns content encrypted with master and its metadata in form S3 returns it
+func encrypt(t *testing.T, master string, content []byte) ([]byte, map[string]*string) {
+	key, err := NewKey(master)
+	assert.NoError(t, err)
+	r, metadata, err := key.Encrypt(bytes.NewReader(content))
+	assert.NoError(t, err)
+	sealed, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	meta := map[string]*string{}
+	for k, v := range metadata {
+		// S3 returns keys in canonical header form
+		meta["Cse-"+k[len(MetaPrefix):]] = aws.String(v)
+	}
+	return sealed, meta
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	t.Parallel()
+
+	for i, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 100} {
+		content := make([]byte, size)
+		_, err := rand.Read(content)
+		assert.NoError(t, err)
+		sealed, meta := encrypt(t, testMaster, content)
+		msg := fmt.Sprintf("they should be equal in iteration %d", i)
+		assert.Equal(t, EncryptedSize(int64(size)), int64(len(sealed)), msg)
+		assert.True(t, IsEncrypted(meta), msg)
+		if size >= ChunkSize {
+			assert.NotContains(t, string(sealed), string(content), msg)
+		}
+
+		key, err := NewKey(testMaster)
+		assert.NoError(t, err)
+		var out bytes.Buffer
+		n, err := key.Decrypt(&out, bytes.NewReader(sealed), meta)
+		assert.NoError(t, err, msg)
+		assert.Equal(t, int64(size), n, msg)
+		assert.True(t, bytes.Equal(content, out.Bytes()), msg)
+	}
+}
+
+func TestDecryptFails(t *testing.T) {
+	t.Parallel()
+
+	content := bytes.Repeat([]byte("secret"), ChunkSize)
+	sealed, meta := encrypt(t, testMaster, content)
+	key, err := NewKey(testMaster)
+	assert.NoError(t, err)
+	other, err := NewKey("10987654321098765432109876543210")
+	assert.NoError(t, err)
+
+	damaged := append([]byte{}, sealed...)
+	damaged[ChunkSize+100] ^= 1
+	cases := []struct {
+		Key     *Key
+		Content []byte
+		Meta    map[string]*string
+		Err     string
+	}{
+		{Key: other, Content: sealed, Meta: meta, Err: "can't unwrap"},
+		{Key: key, Content: damaged, Meta: meta, Err: "chunk 1"},
+		// whole chunks are missing
+		{Key: key, Content: sealed[:ChunkSize+16], Meta: meta, Err: "chunk 0"},
+		{Key: key, Content: sealed, Meta: map[string]*string{}, Err: "unknown encryption algorithm"},
+	}
+	for i, c := range cases {
+		_, err := c.Key.Decrypt(io.Discard, bytes.NewReader(c.Content), c.Meta)
+		assert.ErrorContains(t, err, c.Err, fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewKey("short")
+	assert.ErrorContains(t, err, "32 bytes")
+	assert.Equal(t, int64(16), EncryptedSize(0))
+	assert.Equal(t, int64(ChunkSize+16), EncryptedSize(ChunkSize))
+	assert.Equal(t, int64(ChunkSize+1+32), EncryptedSize(ChunkSize+1))
+
+	_, meta := encrypt(t, testMaster, nil)
+	size := 0
+	for k, v := range meta {
+		size += len(k) + len(*v)
+	}
+	assert.Equal(t, MetadataSize, size)
+}
@@ -0,0 +1,98 @@
+package listing
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// Objects calls fn for every object under prefix in bucket, until fn returns false.
+// Keys ending with slash are folder markers and they are skipped.
+func Objects(client s3iface.S3API, bucket, prefix string, fn func(*s3.Object) bool) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+	}
+	if len(prefix) != 0 {
+		input.Prefix = aws.String(prefix)
+	}
+	stopped := false
+	err := client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			if strings.HasSuffix(aws.StringValue(obj.Key), "/") {
+				continue
+			}
+			if !fn(obj) {
+				stopped = true
+				return false
+			}
+		}
+		return !stopped
+	})
+	if err != nil {
+		return fmt.Errorf("can't list objects in %s/%s: %w", bucket, prefix, err)
+	}
+	return nil
+}
+
+// LocalPath returns where object with key should be stored in dir, when
+// objects under prefix are copied there. Prefix is trimmed only as a whole
+// directory, so key "database/x" isn't under prefix "data".
+func LocalPath(dir, prefix, key string) string {
+	if len(prefix) != 0 && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+	return filepath.Join(dir, filepath.FromSlash(rel))
+}
+
+// Contained returns error if path isn't inside dir, e.g. object key or CSV
+// record has ".." elements, which lead out of it
+func Contained(dir, path string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("can't get absolute path of %s: %w", dir, err)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("can't get absolute path of %s: %w", path, err)
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil || rel == "." || rel == ".." || filepath.IsAbs(rel) ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside of %s", path, dir)
+	}
+	return nil
+}
+
+// DestinationKey returns key of object copied from under prefix to under dstPrefix
+func DestinationKey(prefix, dstPrefix, key string) string {
+	return dstPrefix + strings.TrimPrefix(key, prefix)
+}
+
+// Walk would list all objects under prefix in bucket and would write them
+// to filesChan with destination, which dst returns for their keys
+func Walk(client s3iface.S3API, bucket, prefix string, dst func(key string) string, filesChan chan<- walker.SrcDest) {
+	defer close(filesChan)
+	err := Objects(client, bucket, prefix, func(obj *s3.Object) bool {
+		key := aws.StringValue(obj.Key)
+		log.Debugf("Adding %s to be copied", key)
+		filesChan <- walker.SrcDest{
+			SourceFile:    key,
+			SourceSize:    uint64(aws.Int64Value(obj.Size)),
+			SourceModTime: aws.TimeValue(obj.LastModified),
+			DstObject:     dst(key),
+		}
+		return true
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package listing
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+type mockS3 struct {
+	s3iface.S3API
+	Pages  [][]*s3.Object
+	Prefix *string
+}
+
+func (m *mockS3) ListObjectsV2Pages(inp *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	m.Prefix = inp.Prefix
+	for i, page := range m.Pages {
+		if !fn(&s3.ListObjectsV2Output{Contents: page}, i == len(m.Pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func object(key string, size int64) *s3.Object {
+	return &s3.Object{Key: aws.String(key), Size: aws.Int64(size)}
+}
+
+func TestObjects(t *testing.T) {
+	t.Parallel()
+
+	client := &mockS3{Pages: [][]*s3.Object{
+		{object("dir/", 0), object("dir/a", 1)},
+		{object("dir/b", 2), object("dir/c", 3)},
+	}}
+	var keys []string
+	err := Objects(client, "bucket", "dir/", func(obj *s3.Object) bool {
+		keys = append(keys, aws.StringValue(obj.Key))
+		return len(keys) < 2
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dir/a", "dir/b"}, keys)
+	assert.Equal(t, "dir/", aws.StringValue(client.Prefix))
+}
+
+func TestLocalPath(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Dir    string
+		Prefix string
+		Key    string
+		Path   string
+	}{
+		{Dir: "restore", Prefix: "", Key: "a/b.txt", Path: "restore/a/b.txt"},
+		{Dir: "restore", Prefix: "a/", Key: "a/b.txt", Path: "restore/b.txt"},
+		{Dir: "restore", Prefix: "a", Key: "a/b.txt", Path: "restore/b.txt"},
+		// prefix isn't trimmed from the middle of directory name
+		{Dir: "restore", Prefix: "data", Key: "database/x", Path: "restore/database/x"},
+		{Dir: "restore", Prefix: "data/", Key: "database/x", Path: "restore/database/x"},
+	}
+	for i, c := range cases {
+		assert.Equal(t, filepath.FromSlash(c.Path), LocalPath(c.Dir, c.Prefix, c.Key),
+			fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestContained(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Dir  string
+		Path string
+		Ok   bool
+	}{
+		{Dir: "restore", Path: LocalPath("restore", "data/", "data/a/b.txt"), Ok: true},
+		{Dir: "restore", Path: LocalPath("restore", "data/", "data/a/../b.txt"), Ok: true},
+		{Dir: "restore", Path: LocalPath("restore", "data/", "data/../../etc/cron.d/x"), Ok: false},
+		{Dir: "/restore", Path: LocalPath("/restore", "", "../etc/passwd"), Ok: false},
+		{Dir: "/restore", Path: LocalPath("/restore", "", "a/.."), Ok: false},
+		{Dir: "/restore", Path: "/restore/../restored/x", Ok: false},
+		{Dir: "/restore", Path: "/restore/x", Ok: true},
+		{Dir: ".", Path: "x/y", Ok: true},
+		{Dir: ".", Path: "/etc/passwd", Ok: false},
+	}
+	for i, c := range cases {
+		err := Contained(c.Dir, c.Path)
+		assert.Equal(t, c.Ok, err == nil, fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestDestinationKey(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Prefix    string
+		DstPrefix string
+		Key       string
+		DstKey    string
+	}{
+		{Prefix: "", DstPrefix: "", Key: "a/b.txt", DstKey: "a/b.txt"},
+		{Prefix: "a/", DstPrefix: "", Key: "a/b.txt", DstKey: "b.txt"},
+		{Prefix: "a/", DstPrefix: "c/d/", Key: "a/b.txt", DstKey: "c/d/b.txt"},
+		{Prefix: "", DstPrefix: "backup/", Key: "a/b.txt", DstKey: "backup/a/b.txt"},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.DstKey, DestinationKey(c.Prefix, c.DstPrefix, c.Key),
+			fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+
+	client := &mockS3{Pages: [][]*s3.Object{{object("dir/a", 1), object("dir/sub/b", 2)}}}
+	files := make(chan walker.SrcDest)
+	go Walk(client, "bucket", "dir/", func(key string) string {
+		return LocalPath("restore", "dir/", key)
+	}, files)
+	var got []walker.SrcDest
+	for f := range files {
+		got = append(got, f)
+	}
+	assert.Equal(t, []walker.SrcDest{
+		{SourceFile: "dir/a", SourceSize: 1, DstObject: filepath.FromSlash("restore/a")},
+		{SourceFile: "dir/sub/b", SourceSize: 2, DstObject: filepath.FromSlash("restore/sub/b")},
+	}, got)
+}
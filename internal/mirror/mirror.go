@@ -0,0 +1,115 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	"github.com/sarunask/s3-copy/internal/listing"
+	"github.com/sarunask/s3-copy/internal/retry"
+)
+
+// MaxDeleteBatch is how many keys could be deleted with single DeleteObjects
+const MaxDeleteBatch = 1000
+
+// DirPrefix returns prefix which ends with slash, so keys are put under it
+// like files under directory
+func DirPrefix(prefix string) string {
+	if len(prefix) == 0 || strings.HasSuffix(prefix, "/") {
+		return prefix
+	}
+	return prefix + "/"
+}
+
+// Key returns destination key of local file path, which is in root directory
+func Key(root, prefix, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+	return prefix + filepath.ToSlash(rel)
+}
+
+// Remote returns sizes of all objects under prefix by their keys
+func Remote(client s3iface.S3API, bucket, prefix string) (map[string]int64, error) {
+	remote := map[string]int64{}
+	err := listing.Objects(client, bucket, prefix, func(obj *s3.Object) bool {
+		remote[aws.StringValue(obj.Key)] = aws.Int64Value(obj.Size)
+		return true
+	})
+	return remote, err
+}
+
+// Extraneous returns sorted keys of remote objects, which weren't seen in
+// local tree. Objects of files, which still exist, but weren't walked
+// (e.g. they are excluded), are never extraneous.
+func Extraneous(remote map[string]int64, seen map[string]bool, root, prefix string) []string {
+	var keys []string
+	for key := range remote {
+		if seen[key] {
+			continue
+		}
+		if _, err := os.Lstat(listing.LocalPath(root, prefix, key)); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Deleter removes objects from bucket in batches
+type Deleter struct {
+	S3     s3iface.S3API
+	Bucket string
+	Retry  retry.Policy
+}
+
+// Delete removes keys with DeleteObjects and calls report with result of
+// every key
+func (d *Deleter) Delete(keys []string, report func(key string, err error)) {
+	for start := 0; start < len(keys); start += MaxDeleteBatch {
+		end := start + MaxDeleteBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		d.deleteBatch(keys[start:end], report)
+	}
+}
+
+func (d *Deleter) deleteBatch(keys []string, report func(key string, err error)) {
+	objects := make([]*s3.ObjectIdentifier, 0, len(keys))
+	for _, key := range keys {
+		objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+	}
+	var resp *s3.DeleteObjectsOutput
+	_, _, err := d.Retry.Do(func(int) error {
+		var err error
+		resp, err = d.S3.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(d.Bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		return err
+	})
+	if err != nil {
+		for _, key := range keys {
+			report(key, fmt.Errorf("can't delete objects: %w", err))
+		}
+		return
+	}
+	failed := make(map[string]error, len(resp.Errors))
+	for _, e := range resp.Errors {
+		failed[aws.StringValue(e.Key)] = fmt.Errorf("can't delete %s: %s: %s",
+			aws.StringValue(e.Key), aws.StringValue(e.Code), aws.StringValue(e.Message))
+	}
+	for _, key := range keys {
+		report(key, failed[key])
+	}
+}
@@ -0,0 +1,95 @@
+package mirror
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockS3 struct {
+	s3iface.S3API
+	Batches [][]string
+	Fail    string
+}
+
+func (m *mockS3) DeleteObjects(inp *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	var keys []string
+	resp := &s3.DeleteObjectsOutput{}
+	for _, o := range inp.Delete.Objects {
+		keys = append(keys, aws.StringValue(o.Key))
+		if aws.StringValue(o.Key) == m.Fail {
+			resp.Errors = append(resp.Errors, &s3.Error{Key: o.Key, Code: aws.String("AccessDenied"), Message: aws.String("Access Denied")})
+		}
+	}
+	m.Batches = append(m.Batches, keys)
+	return resp, nil
+}
+
+func TestKey(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Root   string
+		Prefix string
+		Path   string
+		Key    string
+	}{
+		{Root: "data", Prefix: "", Path: "data/a/b.txt", Key: "a/b.txt"},
+		{Root: "data", Prefix: "backup/", Path: "data/a/b.txt", Key: "backup/a/b.txt"},
+		{Root: "data/b.txt", Prefix: "backup/", Path: "data/b.txt", Key: "backup/b.txt"},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.Key, Key(filepath.FromSlash(c.Root), c.Prefix, filepath.FromSlash(c.Path)),
+			fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+	assert.Equal(t, "backup/", DirPrefix("backup"))
+	assert.Equal(t, "backup/", DirPrefix("backup/"))
+	assert.Equal(t, "", DirPrefix(""))
+}
+
+func TestExtraneous(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	// excluded file still exists, so its object is kept
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "excluded.txt"), []byte("x"), 0600))
+	remote := map[string]int64{
+		"backup/walked.txt":   1,
+		"backup/excluded.txt": 1,
+		"backup/gone.txt":     1,
+		"backup/dir/gone.txt": 1,
+	}
+	seen := map[string]bool{"backup/walked.txt": true}
+	assert.Equal(t, []string{"backup/dir/gone.txt", "backup/gone.txt"}, Extraneous(remote, seen, root, "backup/"))
+}
+
+func TestDelete(t *testing.T) {
+	t.Parallel()
+
+	keys := make([]string, MaxDeleteBatch+1)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	client := &mockS3{Fail: "key-5"}
+	d := Deleter{S3: client, Bucket: "bucket"}
+	failed := map[string]error{}
+	reported := 0
+	d.Delete(keys, func(key string, err error) {
+		reported++
+		if err != nil {
+			failed[key] = err
+		}
+	})
+	assert.Equal(t, len(keys), reported)
+	assert.Len(t, client.Batches, 2)
+	assert.Len(t, client.Batches[0], MaxDeleteBatch)
+	assert.Equal(t, []string{"key-1000"}, client.Batches[1])
+	assert.Len(t, failed, 1)
+	assert.ErrorContains(t, failed["key-5"], "AccessDenied")
+}
@@ -0,0 +1,114 @@
+package multipart
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Upload describes multipart upload, which is still in progress
+type Upload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+	Initiator string
+	Parts     int
+	Bytes     int64
+}
+
+// Age returns how long ago upload was started
+func (u Upload) Age(now time.Time) time.Duration {
+	return now.Sub(u.Initiated)
+}
+
+// Filter selects uploads which should be aborted
+type Filter struct {
+	// OlderThan matches uploads started earlier than that, zero disables it
+	OlderThan time.Duration
+	// KeyRegex matches uploads by key, nil disables it
+	KeyRegex *regexp.Regexp
+}
+
+// Match tells if upload is older than OlderThan or its key matches KeyRegex.
+// Empty filter doesn't match anything.
+func (f Filter) Match(u Upload, now time.Time) bool {
+	if f.OlderThan > 0 && u.Age(now) > f.OlderThan {
+		return true
+	}
+	return f.KeyRegex != nil && f.KeyRegex.MatchString(u.Key)
+}
+
+// Cleaner lists and aborts multipart uploads in bucket
+type Cleaner struct {
+	S3     s3iface.S3API
+	Bucket string
+}
+
+// List returns all uploads in progress under prefix with their parts count and size
+func (c *Cleaner) List(prefix string) ([]Upload, error) {
+	var uploads []Upload
+	err := c.S3.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(c.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListMultipartUploadsOutput, _ bool) bool {
+		for _, u := range page.Uploads {
+			upload := Upload{
+				Key:       aws.StringValue(u.Key),
+				UploadID:  aws.StringValue(u.UploadId),
+				Initiated: aws.TimeValue(u.Initiated),
+			}
+			if u.Initiator != nil {
+				upload.Initiator = aws.StringValue(u.Initiator.DisplayName)
+				if len(upload.Initiator) == 0 {
+					upload.Initiator = aws.StringValue(u.Initiator.ID)
+				}
+			}
+			uploads = append(uploads, upload)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't list multipart uploads in %s: %w", c.Bucket, err)
+	}
+	for i := range uploads {
+		if err := c.countParts(&uploads[i]); err != nil {
+			return nil, err
+		}
+	}
+	return uploads, nil
+}
+
+func (c *Cleaner) countParts(u *Upload) error {
+	err := c.S3.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(c.Bucket),
+		Key:      aws.String(u.Key),
+		UploadId: aws.String(u.UploadID),
+	}, func(page *s3.ListPartsOutput, _ bool) bool {
+		for _, p := range page.Parts {
+			u.Parts++
+			u.Bytes += aws.Int64Value(p.Size)
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("can't list parts of %s upload %s: %w", u.Key, u.UploadID, err)
+	}
+	return nil
+}
+
+// Abort aborts upload and frees space used by its parts
+func (c *Cleaner) Abort(u Upload) error {
+	_, err := c.S3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(c.Bucket),
+		Key:      aws.String(u.Key),
+		UploadId: aws.String(u.UploadID),
+	})
+	if err != nil {
+		return fmt.Errorf("can't abort %s upload %s: %w", u.Key, u.UploadID, err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package multipart
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockS3 struct {
+	s3iface.S3API
+	Uploads []*s3.MultipartUpload
+	Parts   map[string][]*s3.Part
+	Aborted []string
+}
+
+func (m *mockS3) ListMultipartUploadsPages(_ *s3.ListMultipartUploadsInput, fn func(*s3.ListMultipartUploadsOutput, bool) bool) error {
+	fn(&s3.ListMultipartUploadsOutput{Uploads: m.Uploads}, true)
+	return nil
+}
+
+func (m *mockS3) ListPartsPages(inp *s3.ListPartsInput, fn func(*s3.ListPartsOutput, bool) bool) error {
+	fn(&s3.ListPartsOutput{Parts: m.Parts[*inp.UploadId]}, true)
+	return nil
+}
+
+func (m *mockS3) AbortMultipartUpload(inp *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	m.Aborted = append(m.Aborted, *inp.UploadId)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	initiated := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	client := &mockS3{
+		Uploads: []*s3.MultipartUpload{
+			{
+				Key:       aws.String("dumps/db.bin"),
+				UploadId:  aws.String("id1"),
+				Initiated: aws.Time(initiated),
+				Initiator: &s3.Initiator{ID: aws.String("arn:aws:iam::1:user/ci")},
+			},
+			{
+				Key:       aws.String("dumps/empty.bin"),
+				UploadId:  aws.String("id2"),
+				Initiated: aws.Time(initiated),
+				Initiator: &s3.Initiator{DisplayName: aws.String("ci"), ID: aws.String("x")},
+			},
+		},
+		Parts: map[string][]*s3.Part{
+			"id1": {
+				{PartNumber: aws.Int64(1), Size: aws.Int64(10)},
+				{PartNumber: aws.Int64(2), Size: aws.Int64(5)},
+			},
+		},
+	}
+	c := Cleaner{S3: client, Bucket: "bucket"}
+	uploads, err := c.List("dumps/")
+	assert.NoError(t, err)
+	assert.Equal(t, []Upload{
+		{
+			Key:       "dumps/db.bin",
+			UploadID:  "id1",
+			Initiated: initiated,
+			Initiator: "arn:aws:iam::1:user/ci",
+			Parts:     2,
+			Bytes:     15,
+		},
+		{
+			Key:       "dumps/empty.bin",
+			UploadID:  "id2",
+			Initiated: initiated,
+			Initiator: "ci",
+		},
+	}, uploads)
+
+	assert.NoError(t, c.Abort(uploads[1]))
+	assert.Equal(t, []string{"id2"}, client.Aborted)
+}
+
+func TestFilterMatch(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	old := Upload{Key: "a/old.bin", Initiated: now.Add(-48 * time.Hour)}
+	fresh := Upload{Key: "a/fresh.tmp", Initiated: now.Add(-time.Minute)}
+
+	assert.False(t, Filter{}.Match(old, now))
+	assert.True(t, Filter{OlderThan: 24 * time.Hour}.Match(old, now))
+	assert.False(t, Filter{OlderThan: 24 * time.Hour}.Match(fresh, now))
+	f := Filter{OlderThan: 24 * time.Hour, KeyRegex: regexp.MustCompile(`\.tmp$`)}
+	assert.True(t, f.Match(old, now))
+	assert.True(t, f.Match(fresh, now))
+}
@@ -0,0 +1,34 @@
+package pool
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+// Run starts a fixed set of workers, which take files from filesList until
+// it's closed. Every file is handed to work and whatever work returns is
+// sent to results. Run returns only after all workers have finished, so it's
+// safe to close results after it.
+func Run(
+	workers int,
+	filesList <-chan walker.SrcDest,
+	results chan<- walker.SrcDest,
+	work func(walker.SrcDest) walker.SrcDest,
+) {
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(id int) {
+			defer wg.Done()
+			for file := range filesList {
+				log.Debugf("worker %d starting on '%#v'", id, file)
+				results <- work(file)
+			}
+			log.Debugf("worker %d has no more work", id)
+		}(i)
+	}
+	wg.Wait()
+}
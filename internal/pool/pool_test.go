@@ -0,0 +1,82 @@
+package pool
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/walker"
+)
+
+func TestRunProcessesAllFiles(t *testing.T) {
+	t.Parallel()
+
+	const filesCount = 50
+	filesList := make(chan walker.SrcDest)
+	results := make(chan walker.SrcDest, filesCount)
+	go func() {
+		defer close(filesList)
+		for i := 0; i < filesCount; i++ {
+			filesList <- walker.SrcDest{SourceFile: fmt.Sprintf("file-%d", i)}
+		}
+	}()
+	Run(4, filesList, results, func(file walker.SrcDest) walker.SrcDest {
+		file.DstObject = file.SourceFile
+		return file
+	})
+	close(results)
+
+	seen := map[string]bool{}
+	for res := range results {
+		assert.Equal(t, res.SourceFile, res.DstObject)
+		seen[res.SourceFile] = true
+	}
+	assert.Len(t, seen, filesCount)
+}
+
+func TestRunKeepsWorkersBusy(t *testing.T) {
+	t.Parallel()
+
+	const workers = 3
+	filesList := make(chan walker.SrcDest)
+	results := make(chan walker.SrcDest, 10)
+	var running, maxRunning int32
+	var mu sync.Mutex
+	slowDone := make(chan struct{})
+	go func() {
+		defer close(filesList)
+		filesList <- walker.SrcDest{SourceFile: "slow"}
+		for i := 0; i < 9; i++ {
+			filesList <- walker.SrcDest{SourceFile: fmt.Sprintf("fast-%d", i)}
+		}
+		// all fast files were taken while slow one is still running
+		close(slowDone)
+	}()
+	Run(workers, filesList, results, func(file walker.SrcDest) walker.SrcDest {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		mu.Lock()
+		if n > maxRunning {
+			maxRunning = n
+		}
+		mu.Unlock()
+		if file.SourceFile == "slow" {
+			select {
+			case <-slowDone:
+			case <-time.After(5 * time.Second):
+				file.Error = fmt.Errorf("other workers were blocked by slow file")
+			}
+		}
+		return file
+	})
+	close(results)
+
+	for res := range results {
+		assert.NoError(t, res.Error)
+	}
+	assert.LessOrEqual(t, maxRunning, int32(workers))
+}
@@ -0,0 +1,204 @@
+package retry
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Class tells what kind of error we got and if it's worth to try again
+type Class string
+
+const (
+	// ClassNone is used when there was no error
+	ClassNone Class = ""
+	// ClassThrottle is used when S3 asks us to slow down
+	ClassThrottle Class = "throttle"
+	// ClassTransient is used for timeouts, connection resets and S3 internal errors
+	ClassTransient Class = "transient"
+	// ClassCredentials is used when credentials expired and should be refreshed
+	ClassCredentials Class = "credentials"
+	// ClassFatal is used for everything, which would fail again
+	ClassFatal Class = "fatal"
+	// ClassMismatch is used when uploaded object differs from local file
+	ClassMismatch Class = "mismatch"
+)
+
+var throttleCodes = map[string]bool{
+	"SlowDown":                  true,
+	"Throttling":                true,
+	"ThrottlingException":       true,
+	"ThrottledException":        true,
+	"RequestThrottled":          true,
+	"RequestThrottledException": true,
+	"RequestLimitExceeded":      true,
+	"TooManyRequestsException":  true,
+}
+
+var credentialsCodes = map[string]bool{
+	"ExpiredToken":          true,
+	"ExpiredTokenException": true,
+	"RequestExpired":        true,
+	"TokenRefreshRequired":  true,
+}
+
+// fatalCodes fail again even if their original errors look transient, e.g.
+// when there are no credentials at all and instance role can't be reached
+var fatalCodes = map[string]bool{
+	"NoCredentialProviders": true,
+}
+
+var transientCodes = map[string]bool{
+	"InternalError":                true,
+	"ServiceUnavailable":           true,
+	"RequestTimeout":               true,
+	"RequestTimeoutException":      true,
+	"RequestTimeTooSkewed":         true,
+	"OperationAborted":             true,
+	request.ErrCodeRequestError:    true,
+	request.ErrCodeRead:            true,
+	request.ErrCodeResponseTimeout: true,
+	request.ErrCodeSerialization:   true,
+}
+
+// Retryable tells if error of class c could go away on next attempt
+func (c Class) Retryable() bool {
+	return c == ClassThrottle || c == ClassTransient || c == ClassCredentials
+}
+
+// Classify sorts err into one of classes
+func Classify(err error) Class {
+	if err == nil {
+		return ClassNone
+	}
+	var aErr awserr.Error
+	if errors.As(err, &aErr) {
+		return classifyAWS(aErr)
+	}
+	return classifyNet(err)
+}
+
+func classifyAWS(aErr awserr.Error) Class {
+	code := aErr.Code()
+	switch {
+	case fatalCodes[code]:
+		return ClassFatal
+	case throttleCodes[code]:
+		return ClassThrottle
+	case credentialsCodes[code]:
+		return ClassCredentials
+	case transientCodes[code]:
+		return ClassTransient
+	}
+	var reqErr awserr.RequestFailure
+	if errors.As(aErr, &reqErr) {
+		switch {
+		case reqErr.StatusCode() == 429:
+			return ClassThrottle
+		case reqErr.StatusCode() >= 500:
+			return ClassTransient
+		}
+	}
+	// s3manager wraps failures of single parts, so look at the part error
+	if orig := aErr.OrigErr(); orig != nil {
+		if class := Classify(orig); class != ClassFatal {
+			return class
+		}
+	}
+	switch {
+	case request.IsErrorThrottle(aErr):
+		return ClassThrottle
+	case request.IsErrorExpiredCreds(aErr):
+		return ClassCredentials
+	case request.IsErrorRetryable(aErr):
+		return ClassTransient
+	}
+	return ClassFatal
+}
+
+func classifyNet(err error) Class {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ClassTransient
+	}
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return ClassTransient
+	}
+	return ClassFatal
+}
+
+// Policy describes how many times and how often we try again
+type Policy struct {
+	// MaxAttempts is how many times we try in total, values below 1 mean 1
+	MaxAttempts int
+	// BaseDelay is delay before second attempt, it's doubled after each attempt
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts
+	MaxDelay time.Duration
+	// Jitter is part of delay in [0,1] range which is randomized,
+	// so workers which failed together don't retry together
+	Jitter float64
+
+	sleep func(time.Duration)
+}
+
+var (
+	rndMu sync.Mutex
+	rnd   = rand.New(rand.NewSource(time.Now().UnixNano())) // nolint:gosec
+)
+
+func random() float64 {
+	rndMu.Lock()
+	defer rndMu.Unlock()
+	return rnd.Float64()
+}
+
+// Delay returns how long to wait after attempt failed
+func (p Policy) Delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(random() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// Do calls fn until it succeeds, fails with error which is not retryable or
+// MaxAttempts is reached. It returns how many attempts were made, class of
+// the last error and the last error itself. Uploads, downloads, copies and
+// key rotations are all retried with it, so they back off the same way.
+func (p Policy) Do(fn func(attempt int) error) (int, Class, error) {
+	sleep := p.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	attempt := 1
+	for ; ; attempt++ {
+		err := fn(attempt)
+		class := Classify(err)
+		if err == nil || !class.Retryable() || attempt >= p.MaxAttempts {
+			return attempt, class, err
+		}
+		delay := p.Delay(attempt)
+		log.Warnf("attempt %d failed with %s error, retrying in %v: %v",
+			attempt, class, delay, err)
+		sleep(delay)
+	}
+}
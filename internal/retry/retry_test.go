@@ -0,0 +1,122 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Err   error
+		Class Class
+	}{
+		{Err: nil, Class: ClassNone},
+		{Err: awserr.New("SlowDown", "Please reduce your request rate.", nil), Class: ClassThrottle},
+		{Err: awserr.NewRequestFailure(awserr.New("Whatever", "", nil), 503, "id"), Class: ClassTransient},
+		{Err: awserr.NewRequestFailure(awserr.New("Whatever", "", nil), 429, "id"), Class: ClassThrottle},
+		{Err: awserr.New("ExpiredToken", "The provided token has expired.", nil), Class: ClassCredentials},
+		{Err: awserr.New(request.ErrCodeRequestError, "send request failed", syscall.ECONNRESET), Class: ClassTransient},
+		// missing credentials don't appear, even if instance role can't be reached
+		{
+			Err:   awserr.New("NoCredentialProviders", "no valid providers in chain", awserr.New(request.ErrCodeRequestError, "", syscall.ECONNREFUSED)),
+			Class: ClassFatal,
+		},
+		{Err: awserr.New("MultipartUpload", "upload multipart failed", awserr.New("SlowDown", "", nil)), Class: ClassThrottle},
+		{Err: fmt.Errorf("failed to upload: %w", awserr.New("AccessDenied", "Access Denied", nil)), Class: ClassFatal},
+		{Err: fmt.Errorf("read: %w", syscall.ECONNRESET), Class: ClassTransient},
+		{Err: fmt.Errorf("failed to open file: %w", os.ErrNotExist), Class: ClassFatal},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.Class, Classify(c.Err),
+			fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestDelay(t *testing.T) {
+	t.Parallel()
+
+	p := Policy{
+		BaseDelay: time.Second,
+		MaxDelay:  5 * time.Second,
+	}
+	assert.Equal(t, time.Second, p.Delay(1))
+	assert.Equal(t, 2*time.Second, p.Delay(2))
+	assert.Equal(t, 4*time.Second, p.Delay(3))
+	assert.Equal(t, 5*time.Second, p.Delay(4))
+	assert.Equal(t, 5*time.Second, p.Delay(100))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.Delay(2)
+		assert.GreaterOrEqual(t, d, time.Second)
+		assert.LessOrEqual(t, d, 2*time.Second)
+	}
+}
+
+func TestDo(t *testing.T) {
+	t.Parallel()
+
+	throttled := awserr.New("SlowDown", "", nil)
+	cases := []struct {
+		Name     string
+		Errors   []error
+		Attempts int
+		Class    Class
+		Err      error
+	}{
+		{
+			Name:     "success on first attempt",
+			Errors:   []error{nil},
+			Attempts: 1,
+			Class:    ClassNone,
+		},
+		{
+			Name:     "success after throttling",
+			Errors:   []error{throttled, throttled, nil},
+			Attempts: 3,
+			Class:    ClassNone,
+		},
+		{
+			Name:     "fatal error is not retried",
+			Errors:   []error{os.ErrNotExist},
+			Attempts: 1,
+			Class:    ClassFatal,
+			Err:      os.ErrNotExist,
+		},
+		{
+			Name:     "gives up after max attempts",
+			Errors:   []error{throttled, throttled, throttled, throttled},
+			Attempts: 3,
+			Class:    ClassThrottle,
+			Err:      throttled,
+		},
+	}
+	for _, c := range cases {
+		var slept []time.Duration
+		p := Policy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+			MaxDelay:    time.Second,
+			sleep: func(d time.Duration) {
+				slept = append(slept, d)
+			},
+		}
+		attempts, class, err := p.Do(func(attempt int) error {
+			return c.Errors[attempt-1]
+		})
+		assert.Equal(t, c.Attempts, attempts, c.Name)
+		assert.Equal(t, c.Class, class, c.Name)
+		assert.True(t, errors.Is(err, c.Err), c.Name)
+		assert.Len(t, slept, c.Attempts-1, c.Name)
+	}
+}
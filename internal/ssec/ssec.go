@@ -0,0 +1,122 @@
+package ssec
+
+import (
+	"crypto/md5" // nolint:gosec
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Encodings of SSE-C keys
+const (
+	EncodingRaw    = "raw"
+	EncodingBase64 = "base64"
+	EncodingHex    = "hex"
+)
+
+// Encodings lists all encodings
+var Encodings = []string{EncodingRaw, EncodingBase64, EncodingHex}
+
+// KeySize is size of AES256 key
+const KeySize = 32
+
+// Source tells where SSE-C key is taken from, only one of them should be set
+type Source struct {
+	Value string
+	File  string
+	Env   string
+	Stdin bool
+}
+
+// count returns how many sources are set
+func (s Source) count() int {
+	n := 0
+	for _, set := range []bool{len(s.Value) != 0, len(s.File) != 0, len(s.Env) != 0, s.Stdin} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// Decode returns raw key of encoded. Whitespace around base64 and hex
+// encoded keys and line end after raw key, which is longer than KeySize,
+// are ignored, so keys could be stored in files with an editor.
+func Decode(encoded, encoding string) (string, error) {
+	switch encoding {
+	case "", EncodingRaw:
+		if len(encoded) > KeySize {
+			encoded = strings.TrimRight(encoded, "\r\n")
+		}
+		return encoded, nil
+	case EncodingBase64:
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return "", fmt.Errorf("key isn't valid base64: %w", err)
+		}
+		return string(key), nil
+	case EncodingHex:
+		key, err := hex.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return "", fmt.Errorf("key isn't valid hex: %w", err)
+		}
+		return string(key), nil
+	}
+	return "", fmt.Errorf("unknown key encoding '%s', should be one of: %s", encoding, strings.Join(Encodings, ", "))
+}
+
+// Load reads key from src and decodes it. Empty key is returned when no
+// source is set.
+func Load(src Source, encoding string, stdin io.Reader) (string, error) {
+	if src.count() > 1 {
+		return "", fmt.Errorf("key should be given only once")
+	}
+	encoded := src.Value
+	switch {
+	case len(src.File) != 0:
+		content, err := os.ReadFile(src.File)
+		if err != nil {
+			return "", fmt.Errorf("can't read key: %w", err)
+		}
+		encoded = string(content)
+	case len(src.Env) != 0:
+		value, ok := os.LookupEnv(src.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s isn't set", src.Env)
+		}
+		encoded = value
+	case src.Stdin:
+		content, err := io.ReadAll(stdin)
+		if err != nil {
+			return "", fmt.Errorf("can't read key from stdin: %w", err)
+		}
+		encoded = string(content)
+	}
+	if len(encoded) == 0 {
+		if src.count() != 0 {
+			return "", fmt.Errorf("key is empty")
+		}
+		return "", nil
+	}
+	key, err := Decode(encoded, encoding)
+	if err != nil {
+		return "", err
+	}
+	if len(key) != KeySize {
+		return "", fmt.Errorf("key must be %d bytes long and not %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// Fingerprint returns base64 encoded MD5 of key, the same S3 returns as
+// SSECustomerKeyMD5 of objects. Empty key has empty fingerprint.
+func Fingerprint(key string) string {
+	if len(key) == 0 {
+		return ""
+	}
+	sum := md5.Sum([]byte(key)) // nolint:gosec
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,82 @@
+package ssec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testKey = "01234567890123456789012345678901"
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Encoded  string
+		Encoding string
+		Err      bool
+	}{
+		{Encoded: testKey, Encoding: EncodingRaw},
+		{Encoded: testKey + "\n", Encoding: EncodingRaw},
+		{Encoded: testKey, Encoding: ""},
+		{Encoded: "MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE=\n", Encoding: EncodingBase64},
+		{Encoded: " 3031323334353637383930313233343536373839303132333435363738393031 ", Encoding: EncodingHex},
+		{Encoded: "not base64!", Encoding: EncodingBase64, Err: true},
+		{Encoded: "zz", Encoding: EncodingHex, Err: true},
+		{Encoded: testKey, Encoding: "rot13", Err: true},
+	}
+	for i, c := range cases {
+		key, err := Decode(c.Encoded, c.Encoding)
+		msg := fmt.Sprintf("they should be equal in iteration %d", i)
+		if c.Err {
+			assert.Error(t, err, msg)
+			continue
+		}
+		assert.NoError(t, err, msg)
+		assert.Equal(t, testKey, key, msg)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	assert.NoError(t, os.WriteFile(path, []byte("MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE=\n"), 0600))
+	t.Setenv("TEST_SSE_C_KEY", "3031323334353637383930313233343536373839303132333435363738393031")
+
+	cases := []struct {
+		Source   Source
+		Encoding string
+		Key      string
+		Err      string
+	}{
+		{Source: Source{}, Key: ""},
+		{Source: Source{Value: testKey}, Key: testKey},
+		{Source: Source{File: path}, Encoding: EncodingBase64, Key: testKey},
+		{Source: Source{Env: "TEST_SSE_C_KEY"}, Encoding: EncodingHex, Key: testKey},
+		{Source: Source{Stdin: true}, Key: testKey},
+		{Source: Source{Env: "TEST_SSE_C_KEY_MISSING"}, Err: "isn't set"},
+		{Source: Source{Value: testKey, Stdin: true}, Err: "only once"},
+		{Source: Source{Value: "short"}, Err: "32 bytes"},
+		{Source: Source{File: path + "-missing"}, Err: "can't read key"},
+	}
+	for i, c := range cases {
+		key, err := Load(c.Source, c.Encoding, strings.NewReader(testKey+"\n"))
+		msg := fmt.Sprintf("they should be equal in iteration %d", i)
+		if len(c.Err) != 0 {
+			assert.ErrorContains(t, err, c.Err, msg)
+			continue
+		}
+		assert.NoError(t, err, msg)
+		assert.Equal(t, c.Key, key, msg)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "", Fingerprint(""))
+	assert.Equal(t, "KYvwGXoFFJ42a2u2GDWhwQ==", Fingerprint(testKey))
+}
@@ -0,0 +1,175 @@
+package throttle
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket, which limits how many bytes per second are
+// read by all readers sharing it. Nil Limiter doesn't limit anything.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewLimiter returns limiter which allows rate bytes per second on average
+// and up to burst bytes at once. Zero burst allows one second of rate.
+func NewLimiter(rate, burst int64) *Limiter {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &Limiter{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// WaitN takes n bytes from bucket and blocks until they are available.
+// Bucket could go into debt, so big reads don't starve.
+func (l *Limiter) WaitN(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if wait > 0 {
+		l.sleep(wait)
+	}
+}
+
+// ReadSeekReaderAt is implemented by os.File and io.SectionReader, s3manager
+// uploads parts of such bodies concurrently without buffering them
+type ReadSeekReaderAt interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+type reader struct {
+	r io.Reader
+	l *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.l.WaitN(n)
+	return n, err
+}
+
+// NewReader returns reader which reads from r not faster than l allows
+func NewReader(r io.Reader, l *Limiter) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{r: r, l: l}
+}
+
+// span is range of file [start,end)
+type span struct {
+	start, end int64
+}
+
+// file counts every byte only once, because SDK reads each part twice:
+// first to calculate its Content-MD5 and then to send it
+type file struct {
+	ReadSeekReaderAt
+	l *Limiter
+
+	mu    sync.Mutex
+	pos   int64
+	spans []span
+}
+
+// charge adds [start,end) to spans and returns how many bytes of it weren't there
+func (f *file) charge(start, end int64) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fresh := end - start
+	merged := make([]span, 0, len(f.spans)+1)
+	for _, s := range f.spans {
+		if s.end < start || s.start > end {
+			merged = append(merged, s)
+			continue
+		}
+		// overlapping or adjacent spans are merged into new one
+		if overlap := minInt64(s.end, end) - maxInt64(s.start, start); overlap > 0 {
+			fresh -= overlap
+		}
+		start = minInt64(s.start, start)
+		end = maxInt64(s.end, end)
+	}
+	merged = append(merged, span{start: start, end: end})
+	f.spans = merged
+	return int(fresh)
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadSeekReaderAt.Read(p)
+	f.mu.Lock()
+	start := f.pos
+	f.pos += int64(n)
+	f.mu.Unlock()
+	f.l.WaitN(f.charge(start, start+int64(n)))
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.ReadSeekReaderAt.ReadAt(p, off)
+	f.l.WaitN(f.charge(off, off+int64(n)))
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.ReadSeekReaderAt.Seek(offset, whence)
+	if err == nil {
+		f.mu.Lock()
+		f.pos = pos
+		f.mu.Unlock()
+	}
+	return pos, err
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// NewFile returns f which is read not faster than l allows. It's still
+// seekable and could be read at any offset, but every byte is counted only
+// once, no matter how many times it's read.
+func NewFile(f ReadSeekReaderAt, l *Limiter) ReadSeekReaderAt {
+	if l == nil {
+		return f
+	}
+	return &file{ReadSeekReaderAt: f, l: l}
+}
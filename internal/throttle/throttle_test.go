@@ -0,0 +1,114 @@
+package throttle
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock moves forward only when limiter sleeps
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.slept += d
+	c.now = c.now.Add(d)
+}
+
+func newTestLimiter(rate, burst int64) (*Limiter, *fakeClock) {
+	c := &fakeClock{now: time.Unix(0, 0)}
+	l := NewLimiter(rate, burst)
+	l.now = c.Now
+	l.sleep = c.Sleep
+	l.last = c.now
+	return l, c
+}
+
+func TestLimiterWaitN(t *testing.T) {
+	t.Parallel()
+
+	l, c := newTestLimiter(1000, 500)
+	// burst is available right away
+	l.WaitN(500)
+	assert.Equal(t, time.Duration(0), c.slept)
+	// then we get rate bytes per second
+	l.WaitN(1000)
+	assert.Equal(t, time.Second, c.slept)
+	l.WaitN(250)
+	assert.Equal(t, 1250*time.Millisecond, c.slept)
+
+	// idle time refills bucket, but not more than burst
+	c.now = c.now.Add(time.Hour)
+	l.WaitN(500)
+	assert.Equal(t, 1250*time.Millisecond, c.slept)
+	l.WaitN(100)
+	assert.Equal(t, 1350*time.Millisecond, c.slept)
+}
+
+func TestNilLimiter(t *testing.T) {
+	t.Parallel()
+
+	var l *Limiter
+	l.WaitN(100)
+	r := strings.NewReader("data")
+	assert.Equal(t, io.Reader(r), NewReader(r, nil))
+	assert.Equal(t, ReadSeekReaderAt(r), NewFile(r, nil))
+}
+
+func TestFile(t *testing.T) {
+	t.Parallel()
+
+	l, c := newTestLimiter(100, 100)
+	data := bytes.Repeat([]byte("0123456789"), 30)
+	f := NewFile(bytes.NewReader(data), l)
+
+	buf := make([]byte, 100)
+	n, err := f.ReadAt(buf, 200)
+	assert.NoError(t, err)
+	assert.Equal(t, data[200:300], buf[:n])
+	assert.Equal(t, time.Duration(0), c.slept)
+
+	_, err = f.Seek(100, io.SeekStart)
+	assert.NoError(t, err)
+	all, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, data[100:], all)
+	// bytes [200,300) were already counted
+	assert.Equal(t, time.Second, c.slept)
+
+	// reading the same bytes again is free
+	_, err = f.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	all, err = io.ReadAll(io.NewSectionReader(f, 100, 200))
+	assert.NoError(t, err)
+	assert.Equal(t, data[100:300], all)
+	assert.Equal(t, time.Second, c.slept)
+	all, err = io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, data, all)
+	assert.Equal(t, 2*time.Second, c.slept)
+}
+
+func TestFileCharge(t *testing.T) {
+	t.Parallel()
+
+	f := &file{}
+	assert.Equal(t, 10, f.charge(0, 10))
+	assert.Equal(t, 10, f.charge(20, 30))
+	assert.Equal(t, 0, f.charge(5, 10))
+	assert.Equal(t, 10, f.charge(5, 25))
+	assert.Equal(t, []span{{start: 0, end: 30}}, f.spans)
+	assert.Equal(t, 5, f.charge(30, 35))
+	assert.Equal(t, 5, f.charge(40, 45))
+	assert.Equal(t, []span{{start: 0, end: 35}, {start: 40, end: 45}}, f.spans)
+}
@@ -0,0 +1,46 @@
+package transfer
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+)
+
+// RefreshWindow is how long before expiration credentials of assumed role are
+// refreshed, so requests in flight aren't signed with expiring ones
+const RefreshWindow = 5 * time.Minute
+
+// Role describes IAM role, which is assumed with credentials of session
+type Role struct {
+	ARN         string
+	ExternalID  string
+	SessionName string
+	// Duration of role session, STS default if zero
+	Duration time.Duration
+	// MFASerial is serial number or ARN of MFA device, if role needs MFA
+	MFASerial string
+}
+
+// roleCredentials returns credentials of role, which are assumed again
+// with client when they are about to expire. tokenProvider is asked for
+// MFA token on every refresh.
+func roleCredentials(client stscreds.AssumeRoler, role Role, tokenProvider func() (string, error)) *credentials.Credentials {
+	return stscreds.NewCredentialsWithClient(client, role.ARN, func(p *stscreds.AssumeRoleProvider) {
+		p.ExpiryWindow = RefreshWindow
+		if len(role.SessionName) != 0 {
+			p.RoleSessionName = role.SessionName
+		}
+		if role.Duration != 0 {
+			p.Duration = role.Duration
+		}
+		if len(role.ExternalID) != 0 {
+			p.ExternalID = aws.String(role.ExternalID)
+		}
+		if len(role.MFASerial) != 0 {
+			p.SerialNumber = aws.String(role.MFASerial)
+			p.TokenProvider = tokenProvider
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package transfer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockSTS issues credentials, which expire after Lifetime
+type mockSTS struct {
+	mu       sync.Mutex
+	Lifetime time.Duration
+	Inputs   []*sts.AssumeRoleInput
+}
+
+func (m *mockSTS) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Inputs = append(m.Inputs, input)
+	return &sts.AssumeRoleOutput{Credentials: &sts.Credentials{
+		AccessKeyId:     aws.String(fmt.Sprintf("AKID%d", len(m.Inputs))),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(m.Lifetime)),
+	}}, nil
+}
+
+func TestRoleCredentials(t *testing.T) {
+	t.Parallel()
+
+	client := &mockSTS{Lifetime: time.Hour}
+	creds := roleCredentials(client, Role{
+		ARN:         "arn:aws:iam::111122223333:role/copy",
+		ExternalID:  "ext",
+		SessionName: "ci",
+		Duration:    2 * time.Hour,
+		MFASerial:   "arn:aws:iam::111122223333:mfa/ci",
+	}, func() (string, error) { return "123456", nil })
+	v, err := creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "AKID1", v.AccessKeyID)
+	// credentials are cached until they are about to expire
+	v, err = creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "AKID1", v.AccessKeyID)
+	assert.Len(t, client.Inputs, 1)
+	input := client.Inputs[0]
+	assert.Equal(t, "arn:aws:iam::111122223333:role/copy", aws.StringValue(input.RoleArn))
+	assert.Equal(t, "ext", aws.StringValue(input.ExternalId))
+	assert.Equal(t, "ci", aws.StringValue(input.RoleSessionName))
+	assert.Equal(t, int64(7200), aws.Int64Value(input.DurationSeconds))
+	assert.Equal(t, "arn:aws:iam::111122223333:mfa/ci", aws.StringValue(input.SerialNumber))
+	assert.Equal(t, "123456", aws.StringValue(input.TokenCode))
+}
+
+func TestRoleCredentialsRefresh(t *testing.T) {
+	t.Parallel()
+
+	// credentials expiring within RefreshWindow are assumed again
+	client := &mockSTS{Lifetime: RefreshWindow - time.Second}
+	creds := roleCredentials(client, Role{ARN: "arn:aws:iam::111122223333:role/copy"}, nil)
+	for i := 1; i <= 3; i++ {
+		v, err := creds.Get()
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("AKID%d", i), v.AccessKeyID, fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+	assert.Nil(t, client.Inputs[0].SerialNumber)
+	assert.Nil(t, client.Inputs[0].ExternalId)
+	assert.NotEmpty(t, aws.StringValue(client.Inputs[0].RoleSessionName))
+}
@@ -0,0 +1,145 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Options describe how Engine should connect to S3
+type Options struct {
+	Region    string
+	DebugHTTP bool
+	// MaxConns limits how many connections to S3 could be open at once,
+	// it should be at least workers count times concurrent parts per file
+	MaxConns int
+	// EndpointURL of S3 compatible storage like MinIO or Ceph RGW, empty means AWS
+	EndpointURL string
+	// ForcePathStyle puts bucket into path instead of host name, as most
+	// S3 compatible servers expect
+	ForcePathStyle bool
+	// DisableSSL uses HTTP instead of HTTPS
+	DisableSSL bool
+	// CABundle is PEM file with certificates of CAs, which are trusted instead
+	// of system ones. It takes precedence over AWS_CA_BUNDLE.
+	CABundle string
+	// Profile of shared config and credentials files, default chain is used if empty
+	Profile string
+	// Role is assumed with credentials of profile or default chain, if its ARN is set
+	Role Role
+	// TokenProvider returns MFA token of Role or of role in Profile
+	TokenProvider func() (string, error)
+}
+
+// Engine is built once and shared by all workers, so they reuse
+// connections and cached credentials instead of building their own
+type Engine struct {
+	Session    *session.Session
+	HTTPClient *http.Client
+	S3         s3iface.S3API
+	Uploader   s3manageriface.UploaderAPI
+	Downloader s3manageriface.DownloaderAPI
+}
+
+// readCABundle returns content of PEM file at path, if it has any certificates
+func readCABundle(path string) ([]byte, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read CA bundle: %w", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+	return pem, nil
+}
+
+// newHTTPClient returns client which keeps up to maxConns connections to S3
+// open, so parallel uploads don't need new TCP and TLS handshakes
+func newHTTPClient(maxConns int) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			MaxIdleConns:          maxConns,
+			MaxIdleConnsPerHost:   maxConns,
+			MaxConnsPerHost:       maxConns,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
+
+// New will create AWS session, HTTP client, uploader and downloader for opts
+func New(opts Options) (*Engine, error) {
+	if opts.MaxConns < 1 {
+		return nil, fmt.Errorf("max connections must be positive and not %d", opts.MaxConns)
+	}
+	var logLevel aws.LogLevelType
+	if opts.DebugHTTP {
+		logLevel = aws.LogDebugWithHTTPBody
+	}
+	client := newHTTPClient(opts.MaxConns)
+	sessOpts := session.Options{
+		Config: aws.Config{
+			Region:     aws.String(opts.Region),
+			LogLevel:   &logLevel,
+			HTTPClient: client,
+		},
+		Profile:                 opts.Profile,
+		AssumeRoleDuration:      opts.Role.Duration,
+		AssumeRoleTokenProvider: opts.TokenProvider,
+	}
+	if len(opts.Profile) != 0 {
+		// role_arn and source_profile of profile are only read from config file
+		sessOpts.SharedConfigState = session.SharedConfigEnable
+	}
+	if len(opts.CABundle) != 0 {
+		pem, err := readCABundle(opts.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		sessOpts.CustomCABundle = bytes.NewReader(pem)
+	}
+	sess, err := session.NewSessionWithOptions(sessOpts)
+	if err != nil {
+		return nil, fmt.Errorf("can't create AWS session: %w", err)
+	}
+	if len(opts.Role.ARN) != 0 {
+		sess = sess.Copy(&aws.Config{
+			Credentials: roleCredentials(sts.New(sess), opts.Role, opts.TokenProvider),
+		})
+	}
+	// endpoint options are only for S3, STS is still called in AWS
+	s3Config := &aws.Config{
+		S3ForcePathStyle: aws.Bool(opts.ForcePathStyle),
+		DisableSSL:       aws.Bool(opts.DisableSSL),
+	}
+	if len(opts.EndpointURL) != 0 {
+		s3Config.Endpoint = aws.String(opts.EndpointURL)
+	}
+	s3Client := s3.New(sess, s3Config)
+	return &Engine{
+		Session:    sess,
+		HTTPClient: client,
+		S3:         s3Client,
+		Uploader:   s3manager.NewUploaderWithClient(s3Client),
+		Downloader: s3manager.NewDownloaderWithClient(s3Client),
+	}, nil
+}
@@ -0,0 +1,107 @@
+package transfer
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	e, err := New(Options{
+		Region:   "eu-west-1",
+		MaxConns: 25,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", aws.StringValue(e.Session.Config.Region))
+	assert.Same(t, e.HTTPClient, e.Session.Config.HTTPClient)
+	tr, ok := e.HTTPClient.Transport.(*http.Transport)
+	assert.True(t, ok)
+	assert.Equal(t, 25, tr.MaxIdleConnsPerHost)
+	assert.Equal(t, 25, tr.MaxConnsPerHost)
+	assert.NotNil(t, e.S3)
+	assert.NotNil(t, e.Uploader)
+}
+
+func TestNewBadMaxConns(t *testing.T) {
+	t.Parallel()
+
+	_, err := New(Options{Region: "eu-west-1"})
+	assert.Error(t, err)
+}
+
+// s3Server records paths of requests and answers every HEAD with empty object
+func s3Server(paths chan<- string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.Host + r.URL.Path
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// setTestCredentials makes session use static credentials instead of looking for them
+func setTestCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+}
+
+func TestNewEndpoint(t *testing.T) {
+	setTestCredentials(t)
+	paths := make(chan string, 1)
+	srv := httptest.NewServer(s3Server(paths))
+	defer srv.Close()
+
+	e, err := New(Options{
+		Region:         "us-east-1",
+		MaxConns:       1,
+		EndpointURL:    srv.URL,
+		ForcePathStyle: true,
+	})
+	assert.NoError(t, err)
+	_, err = e.S3.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("dir/key")})
+	assert.NoError(t, err)
+	assert.Equal(t, strings.TrimPrefix(srv.URL, "http://")+"/bucket/dir/key", <-paths)
+}
+
+func TestNewCABundle(t *testing.T) {
+	setTestCredentials(t)
+	paths := make(chan string, 1)
+	srv := httptest.NewTLSServer(s3Server(paths))
+	defer srv.Close()
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(bundle, cert, 0600))
+	e, err := New(Options{
+		Region:         "us-east-1",
+		MaxConns:       1,
+		EndpointURL:    srv.URL,
+		ForcePathStyle: true,
+		CABundle:       bundle,
+	})
+	assert.NoError(t, err)
+	_, err = e.S3.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key")})
+	assert.NoError(t, err)
+	assert.Equal(t, strings.TrimPrefix(srv.URL, "https://")+"/bucket/key", <-paths)
+
+	// certificate of server isn't trusted without bundle
+	e, err = New(Options{Region: "us-east-1", MaxConns: 1, EndpointURL: srv.URL, ForcePathStyle: true})
+	assert.NoError(t, err)
+	_, err = e.S3.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key")})
+	assert.Error(t, err)
+
+	_, err = New(Options{Region: "us-east-1", MaxConns: 1, CABundle: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.ErrorContains(t, err, "can't read CA bundle")
+	_, err = New(Options{Region: "us-east-1", MaxConns: 1, CABundle: "transfer.go"})
+	assert.ErrorContains(t, err, "no certificates")
+}
@@ -0,0 +1,71 @@
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Sizes which are accepted as suffixes
+const (
+	KiB int64 = 1 << (10 * (iota + 1))
+	MiB
+	GiB
+	TiB
+)
+
+var suffixes = []struct {
+	suffix     string
+	multiplier int64
+}{
+	// longer suffixes go first, so "MiB" isn't taken for "B"
+	{"KiB", KiB},
+	{"MiB", MiB},
+	{"GiB", GiB},
+	{"TiB", TiB},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", KiB},
+	{"M", MiB},
+	{"G", GiB},
+	{"T", TiB},
+	{"B", 1},
+}
+
+// ParseBytes parses size like "10MiB", "1GB" or "1048576" into bytes.
+// Suffixes are case insensitive, KiB/MiB/GiB/TiB and K/M/G/T are powers of
+// 1024, while KB/MB/GB/TB are powers of 1000.
+func ParseBytes(s string) (int64, error) {
+	str := strings.TrimSpace(s)
+	multiplier := int64(1)
+	for _, suf := range suffixes {
+		if len(str) > len(suf.suffix) && strings.EqualFold(str[len(str)-len(suf.suffix):], suf.suffix) {
+			multiplier = suf.multiplier
+			str = strings.TrimSpace(str[:len(str)-len(suf.suffix)])
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad size '%s': %w", s, err)
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("size '%s' must not be negative", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// ParseRate parses rate like "50MiB/s" or "50MiB" into bytes per second
+func ParseRate(s string) (int64, error) {
+	str := strings.TrimSpace(s)
+	if strings.HasSuffix(strings.ToLower(str), "/s") {
+		str = str[:len(str)-2]
+	}
+	rate, err := ParseBytes(str)
+	if err != nil {
+		return 0, fmt.Errorf("bad rate '%s': %w", s, err)
+	}
+	return rate, nil
+}
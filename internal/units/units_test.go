@@ -0,0 +1,53 @@
+package units
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBytes(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		In   string
+		Out  int64
+		Fail bool
+	}{
+		{In: "1048576", Out: MiB},
+		{In: "10MiB", Out: 10 * MiB},
+		{In: "10mib", Out: 10 * MiB},
+		{In: "10 MiB", Out: 10 * MiB},
+		{In: "10M", Out: 10 * MiB},
+		{In: "10MB", Out: 10 * 1000 * 1000},
+		{In: "1.5GiB", Out: GiB + GiB/2},
+		{In: "5TiB", Out: 5 * TiB},
+		{In: "512B", Out: 512},
+		{In: "MiB", Fail: true},
+		{In: "ten", Fail: true},
+		{In: "-1MiB", Fail: true},
+	}
+	for i, c := range cases {
+		out, err := ParseBytes(c.In)
+		if c.Fail {
+			assert.Error(t, err, fmt.Sprintf("iteration %d", i))
+			continue
+		}
+		assert.NoError(t, err, fmt.Sprintf("iteration %d", i))
+		assert.Equal(t, c.Out, out, fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	t.Parallel()
+
+	rate, err := ParseRate("50MiB/s")
+	assert.NoError(t, err)
+	assert.Equal(t, 50*MiB, rate)
+	rate, err = ParseRate("1GB")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000*1000*1000), rate)
+	_, err = ParseRate("fast/s")
+	assert.Error(t, err)
+}
@@ -0,0 +1,239 @@
+package walker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/sarunask/s3-copy/internal/envelope"
+	"github.com/sarunask/s3-copy/internal/ssec"
+)
+
+// Columns of CSV file header, source and destination are required and
+// the others are optional
+const (
+	ColumnSource       = "source"
+	ColumnDestination  = "destination"
+	ColumnStorageClass = "storage_class"
+	ColumnContentType  = "content_type"
+	ColumnMetadata     = "metadata"
+	ColumnTags         = "tags"
+	ColumnSSECKey      = "sse_c_key"
+	ColumnSSE          = "sse"
+	ColumnSSEKMSKeyID  = "sse_kms_key_id"
+	ColumnSHA256       = "sha256"
+)
+
+var optionalColumns = []string{
+	ColumnStorageClass, ColumnContentType, ColumnMetadata, ColumnTags, ColumnSSECKey, ColumnSSE, ColumnSSEKMSKeyID,
+	ColumnSHA256,
+}
+
+// SSEKMSDSSE is dual-layer SSE-KMS, which our SDK version has no constant for
+const SSEKMSDSSE = "aws:kms:dsse"
+
+// sseAlgorithms are server-side encryption algorithms of sse column
+var sseAlgorithms = append(s3.ServerSideEncryption_Values(), SSEKMSDSSE)
+
+// S3 limits of tags and user metadata
+const (
+	maxTags        = 10
+	maxTagKeyLen   = 128
+	maxTagValueLen = 256
+	maxMetadataLen = 2048
+)
+
+// metadataKeyRe matches keys allowed in HTTP header names
+var metadataKeyRe = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// ValidateTags checks that tags fit S3 limits
+func ValidateTags(tags map[string]string) error {
+	if len(tags) > maxTags {
+		return fmt.Errorf("object could have up to %d tags and not %d", maxTags, len(tags))
+	}
+	for k, v := range tags {
+		if len(k) > maxTagKeyLen || len(v) > maxTagValueLen {
+			return fmt.Errorf("tag key should be up to %d and value up to %d characters long: '%s'",
+				maxTagKeyLen, maxTagValueLen, k)
+		}
+	}
+	return nil
+}
+
+// ValidateMetadata checks that user metadata has keys allowed in HTTP
+// headers and not reserved for SHA-256 of file and client-side encryption.
+// It should fit S3 limit with metadata added to every file, which is
+// bigger when files are encrypted on client side.
+func ValidateMetadata(metadata map[string]string, encrypted bool) error {
+	// sha256 of file is stored in metadata too
+	size := len(ColumnSHA256) + 64
+	if encrypted {
+		size += envelope.MetadataSize
+	}
+	for k, v := range metadata {
+		if strings.EqualFold(k, ColumnSHA256) {
+			return fmt.Errorf("metadata key '%s' is reserved for SHA-256 of files", k)
+		}
+		if strings.HasPrefix(strings.ToLower(k), envelope.MetaPrefix) {
+			return fmt.Errorf("metadata keys starting with '%s' are reserved for client-side encryption", envelope.MetaPrefix)
+		}
+		if !metadataKeyRe.MatchString(k) {
+			return fmt.Errorf("metadata key '%s' has characters not allowed in HTTP header", k)
+		}
+		size += len(k) + len(v)
+	}
+	if size > maxMetadataLen {
+		return fmt.Errorf("user metadata should be up to %d bytes and not %d", maxMetadataLen, size)
+	}
+	return nil
+}
+
+// Options are upload options of single CSV row, empty ones are taken from
+// global settings
+type Options struct {
+	StorageClass string
+	ContentType  string
+	// Metadata and Tags are added to global ones
+	Metadata map[string]string
+	Tags     map[string]string
+	// SSECKey replaces global SSE-C key
+	SSECKey string
+	// SSE and SSEKMSKeyID replace global server-side encryption and SSE-C key
+	SSE         string
+	SSEKMSKeyID string
+	// SHA256 is expected SHA-256 of file
+	SHA256 string
+}
+
+// ChangesObject tells if options change uploaded object, expected SHA-256
+// only checks the file. Nil options don't change it.
+func (o *Options) ChangesObject() bool {
+	return o != nil && (len(o.StorageClass) != 0 || len(o.ContentType) != 0 ||
+		len(o.Metadata) != 0 || len(o.Tags) != 0 ||
+		len(o.SSECKey) != 0 || len(o.SSE) != 0 || len(o.SSEKMSKeyID) != 0)
+}
+
+// header maps names of optional columns to their indexes
+type header map[string]int
+
+// parseHeader returns header if the first record is one. It's a header
+// when its first two columns are source and destination.
+func parseHeader(recs [][]string) (header, error) {
+	if len(recs) == 0 || len(recs[0]) < 2 ||
+		!strings.EqualFold(strings.TrimSpace(recs[0][0]), ColumnSource) ||
+		!strings.EqualFold(strings.TrimSpace(recs[0][1]), ColumnDestination) {
+		return nil, nil
+	}
+	h := header{}
+	for i, name := range recs[0][2:] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, c := range optionalColumns {
+			known = known || c == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column '%s', should be one of: %s", name, strings.Join(optionalColumns, ", "))
+		}
+		if _, ok := h[name]; ok {
+			return nil, fmt.Errorf("column '%s' is given more than once", name)
+		}
+		h[name] = i + 2
+	}
+	return h, nil
+}
+
+// parsePairs parses k=v;k2=v2 into map
+func parsePairs(s string) (map[string]string, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	pairs := map[string]string{}
+	for _, pair := range strings.Split(s, ";") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || len(k) == 0 {
+			return nil, fmt.Errorf("'%s' should be key=value", pair)
+		}
+		pairs[k] = v
+	}
+	return pairs, nil
+}
+
+// options returns options of rec or nil if there are none, SSE-C key is
+// decoded with keyEncoding
+func (h header) options(rec []string, keyEncoding string) (*Options, error) {
+	if len(h) == 0 {
+		return nil, nil
+	}
+	column := func(name string) string {
+		if i, ok := h[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	var err error
+	opts := &Options{
+		StorageClass: column(ColumnStorageClass),
+		ContentType:  column(ColumnContentType),
+		SSECKey:      column(ColumnSSECKey),
+		SSE:          column(ColumnSSE),
+		SSEKMSKeyID:  column(ColumnSSEKMSKeyID),
+		SHA256:       column(ColumnSHA256),
+	}
+	if opts.Metadata, err = parsePairs(column(ColumnMetadata)); err != nil {
+		return nil, fmt.Errorf("bad metadata: %w", err)
+	}
+	// row is checked with global metadata and tags again before upload
+	if err := ValidateMetadata(opts.Metadata, false); err != nil {
+		return nil, err
+	}
+	if opts.Tags, err = parsePairs(column(ColumnTags)); err != nil {
+		return nil, fmt.Errorf("bad tags: %w", err)
+	}
+	if err := ValidateTags(opts.Tags); err != nil {
+		return nil, err
+	}
+	if len(opts.StorageClass) != 0 {
+		known := false
+		for _, c := range s3.ObjectStorageClass_Values() {
+			known = known || c == opts.StorageClass
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown storage class '%s'", opts.StorageClass)
+		}
+	}
+	if len(opts.SSECKey) != 0 {
+		if opts.SSECKey, err = ssec.Decode(opts.SSECKey, keyEncoding); err != nil {
+			return nil, fmt.Errorf("bad SSE-C key: %w", err)
+		}
+		if len(opts.SSECKey) != ssec.KeySize {
+			return nil, fmt.Errorf("SSE-C key must be %d bytes long and not %d", ssec.KeySize, len(opts.SSECKey))
+		}
+	}
+	if err := opts.validateSSE(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// validateSSE checks that server-side encryption of row is known and
+// isn't combined with SSE-C
+func (o *Options) validateSSE() error {
+	if len(o.SSE) != 0 {
+		known := false
+		for _, alg := range sseAlgorithms {
+			known = known || alg == o.SSE
+		}
+		if !known {
+			return fmt.Errorf("unknown sse '%s', should be one of: %s", o.SSE, strings.Join(sseAlgorithms, ", "))
+		}
+	}
+	switch {
+	case len(o.SSE) != 0 && len(o.SSECKey) != 0:
+		return fmt.Errorf("sse %s can't be used together with SSE-C key", o.SSE)
+	case len(o.SSEKMSKeyID) != 0 && o.SSE != s3.ServerSideEncryptionAwsKms && o.SSE != SSEKMSDSSE:
+		return fmt.Errorf("sse_kms_key_id needs sse %s or %s", s3.ServerSideEncryptionAwsKms, SSEKMSDSSE)
+	}
+	return nil
+}
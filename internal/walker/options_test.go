@@ -0,0 +1,173 @@
+package walker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sarunask/s3-copy/internal/ssec"
+)
+
+func TestParseHeader(t *testing.T) {
+	t.Parallel()
+
+	h, err := parseHeader([][]string{{"./file", "key"}})
+	assert.NoError(t, err)
+	assert.Nil(t, h)
+
+	h, err = parseHeader([][]string{{"Source", "destination", "tags", " STORAGE_CLASS "}})
+	assert.NoError(t, err)
+	assert.Equal(t, header{ColumnTags: 2, ColumnStorageClass: 3}, h)
+
+	_, err = parseHeader([][]string{{"source", "destination", "colour"}})
+	assert.ErrorContains(t, err, "unknown column")
+	_, err = parseHeader([][]string{{"source", "destination", "tags", "tags"}})
+	assert.ErrorContains(t, err, "more than once")
+}
+
+func TestHeaderOptions(t *testing.T) {
+	t.Parallel()
+
+	h := header{
+		ColumnStorageClass: 2,
+		ColumnContentType:  3,
+		ColumnMetadata:     4,
+		ColumnTags:         5,
+		ColumnSSECKey:      6,
+		ColumnSHA256:       7,
+	}
+	cases := []struct {
+		Rec      []string
+		Encoding string
+		Options  *Options
+		Err      string
+	}{
+		{
+			Rec: []string{"f", "k", "GLACIER_IR", "text/csv", "a=1;b=2", "team=data", "01234567890123456789012345678901", "abc"},
+			Options: &Options{
+				StorageClass: "GLACIER_IR",
+				ContentType:  "text/csv",
+				Metadata:     map[string]string{"a": "1", "b": "2"},
+				Tags:         map[string]string{"team": "data"},
+				SSECKey:      "01234567890123456789012345678901",
+				SHA256:       "abc",
+			},
+		},
+		{
+			Rec:     []string{"f", "k", "", "", "", "", "", ""},
+			Options: &Options{},
+		},
+		{Rec: []string{"f", "k", "COLD", "", "", "", "", ""}, Err: "unknown storage class"},
+		{Rec: []string{"f", "k", "", "", "a", "", "", ""}, Err: "bad metadata"},
+		{Rec: []string{"f", "k", "", "", "SHA256=x", "", "", ""}, Err: "reserved"},
+		{Rec: []string{"f", "k", "", "", "CSE-alg=x", "", "", ""}, Err: "reserved for client-side encryption"},
+		{Rec: []string{"f", "k", "", "", "a b=x", "", "", ""}, Err: "not allowed in HTTP header"},
+		{Rec: []string{"f", "k", "", "", "a=" + strings.Repeat("x", 2000), "", "", ""}, Err: "up to 2048 bytes"},
+		{Rec: []string{"f", "k", "", "", "", "a=1;b=2;c=3;d=4;e=5;f=6;g=7;h=8;i=9;j=10;k=11", "", ""}, Err: "up to 10 tags"},
+		{Rec: []string{"f", "k", "", "", "", "a=" + strings.Repeat("x", 257), "", ""}, Err: "characters long"},
+		{Rec: []string{"f", "k", "", "", "", "=x", "", ""}, Err: "bad tags"},
+		{
+			Rec:      []string{"f", "k", "", "", "", "", "MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE=", ""},
+			Encoding: ssec.EncodingBase64,
+			Options:  &Options{SSECKey: "01234567890123456789012345678901"},
+		},
+		{Rec: []string{"f", "k", "", "", "", "", "short", ""}, Err: "32 bytes"},
+		{Rec: []string{"f", "k", "", "", "", "", "zz", ""}, Encoding: ssec.EncodingHex, Err: "bad SSE-C key"},
+	}
+	for i, c := range cases {
+		opts, err := h.options(c.Rec, c.Encoding)
+		if len(c.Err) != 0 {
+			assert.ErrorContains(t, err, c.Err, fmt.Sprintf("they should be equal in iteration %d", i))
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, c.Options, opts, fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+	opts, err := header(nil).options([]string{"f", "k"}, ssec.EncodingRaw)
+	assert.NoError(t, err)
+	assert.Nil(t, opts)
+}
+
+func TestHeaderSSEOptions(t *testing.T) {
+	t.Parallel()
+
+	h := header{ColumnSSECKey: 2, ColumnSSE: 3, ColumnSSEKMSKeyID: 4}
+	cases := []struct {
+		Rec     []string
+		Options *Options
+		Err     string
+	}{
+		{Rec: []string{"f", "k", "", "AES256", ""}, Options: &Options{SSE: "AES256"}},
+		{Rec: []string{"f", "k", "", "aws:kms", "alias/data"}, Options: &Options{SSE: "aws:kms", SSEKMSKeyID: "alias/data"}},
+		{Rec: []string{"f", "k", "", "aws:kms:dsse", "alias/data"}, Options: &Options{SSE: "aws:kms:dsse", SSEKMSKeyID: "alias/data"}},
+		{Rec: []string{"f", "k", "", "", ""}, Options: &Options{}},
+		{Rec: []string{"f", "k", "", "DES", ""}, Err: "unknown sse"},
+		{Rec: []string{"f", "k", "01234567890123456789012345678901", "AES256", ""}, Err: "can't be used together"},
+		{Rec: []string{"f", "k", "", "AES256", "alias/data"}, Err: "needs sse"},
+		{Rec: []string{"f", "k", "", "", "alias/data"}, Err: "needs sse"},
+	}
+	for i, c := range cases {
+		opts, err := h.options(c.Rec, ssec.EncodingRaw)
+		if len(c.Err) != 0 {
+			assert.ErrorContains(t, err, c.Err, fmt.Sprintf("they should be equal in iteration %d", i))
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, c.Options, opts, fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestChangesObject(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		Options *Options
+		Changes bool
+	}{
+		{Options: nil},
+		{Options: &Options{}},
+		{Options: &Options{SHA256: "abc"}},
+		{Options: &Options{StorageClass: "GLACIER_IR"}, Changes: true},
+		{Options: &Options{ContentType: "text/csv"}, Changes: true},
+		{Options: &Options{Metadata: map[string]string{"a": "1"}}, Changes: true},
+		{Options: &Options{Tags: map[string]string{"a": "1"}}, Changes: true},
+		{Options: &Options{SSECKey: "01234567890123456789012345678901"}, Changes: true},
+		{Options: &Options{SSE: "AES256"}, Changes: true},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.Changes, c.Options.ChangesObject(), fmt.Sprintf("they should be equal in iteration %d", i))
+	}
+}
+
+func TestUseCSVFileWithHeader(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test1.txt")
+	assert.NoError(t, os.WriteFile(file, []byte("Some text for test"), 0600))
+	csvPath := filepath.Join(dir, "input.csv")
+	assert.NoError(t, os.WriteFile(csvPath, []byte(
+		"source,destination,storage_class,sha256\n"+
+			file+",good.txt,STANDARD_IA,d56ddee7d0fe47470cc19775dbe3ebc01b80bfee1f917b7fe3796b5ce7fb3d16\n"+
+			file+",bad.txt,,0000\n"), 0600))
+
+	files := make(chan SrcDest)
+	errors := make(chan SrcDest, 1)
+	go UseCSVFile(csvPath, files, errors, time.Time{})
+	var got []SrcDest
+	for f := range files {
+		got = append(got, f)
+	}
+	assert.Len(t, got, 1)
+	assert.Equal(t, "good.txt", got[0].DstObject)
+	assert.Equal(t, &Options{StorageClass: "STANDARD_IA",
+		SHA256: "d56ddee7d0fe47470cc19775dbe3ebc01b80bfee1f917b7fe3796b5ce7fb3d16"}, got[0].Options)
+	bad := <-errors
+	assert.Equal(t, "bad.txt", bad.DstObject)
+	assert.ErrorContains(t, bad.Error, "instead of expected 0000")
+}
@@ -12,6 +12,20 @@ import (
 	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/sarunask/s3-copy/internal/ssec"
+	"github.com/sarunask/s3-copy/internal/throttle"
+)
+
+// Statuses of files which were transferred without error
+const (
+	StatusUploaded   = "uploaded"
+	StatusSkipped    = "skipped"
+	StatusDownloaded = "downloaded"
+	StatusCopied     = "copied"
+	StatusDeleted    = "deleted"
+	StatusRotated    = "rotated"
+	StatusArchived   = "archived"
 )
 
 type (
@@ -19,11 +33,45 @@ type (
 		SourceFile   string
 		SourceSha256 string
 		SourceSize   uint64
-		DstObject    string
-		Error        error
+		// SourceModTime is modification time of file when it was hashed
+		SourceModTime time.Time
+		DstObject     string
+		Error         error
+		// Attempts is how many times we tried to transfer the file
+		Attempts int
+		// ErrorClass is class of the last error, see retry.Class
+		ErrorClass string
+		// Status tells what was done with file, which had no error
+		Status string
+		// AfterAction tells what was done with local file after upload
+		AfterAction string
+		// CompressedSha256 and CompressedSize describe uploaded content,
+		// if file was compressed
+		CompressedSha256 string
+		CompressedSize   uint64
+		// KeyMD5 is fingerprint of SSE-C key of the object, empty if it isn't SSE-C encrypted
+		KeyMD5 string
+		// Options of CSV row, nil if there are none
+		Options *Options
 	}
 )
 
+// hashLimiter limits how fast files are read to calculate their sums
+var hashLimiter *throttle.Limiter
+
+// keyEncoding is encoding of SSE-C keys in CSV files, see ssec.Decode
+var keyEncoding = ssec.EncodingRaw
+
+// SetKeyEncoding sets encoding of SSE-C keys in CSV files
+func SetKeyEncoding(encoding string) {
+	keyEncoding = encoding
+}
+
+// SetHashLimiter limits how fast all files are read for hashing, nil removes the limit
+func SetHashLimiter(l *throttle.Limiter) {
+	hashLimiter = l
+}
+
 // Walk would recursivly get all files (except but excluded)
 // And would write files path to fileChan channel
 func Walk(walkPath string, filesChan chan<- SrcDest, errors chan<- SrcDest, excludes *[]string, newerThan time.Time) {
@@ -33,7 +81,7 @@ func Walk(walkPath string, filesChan chan<- SrcDest, errors chan<- SrcDest, excl
 		// Only append files which are not dirs and we don't need 2 skip that file
 		if f != nil && !f.IsDir() && !need2skip(path, excludes) && !need2SkipOlderThan(path, newerThan) {
 			log.Debugf("Adding %s to be copied", path)
-			sum, size, err := getSizeAndSum(path)
+			sum, size, modTime, err := getSizeAndSum(path)
 			if err != nil {
 				errors <- SrcDest{
 					SourceFile: path,
@@ -43,34 +91,65 @@ func Walk(walkPath string, filesChan chan<- SrcDest, errors chan<- SrcDest, excl
 				return err
 			}
 			filesChan <- SrcDest{
-				SourceFile:   path,
-				SourceSha256: sum,
-				SourceSize:   size,
-				DstObject:    filepath.Base(path),
+				SourceFile:    path,
+				SourceSha256:  sum,
+				SourceSize:    size,
+				SourceModTime: modTime,
+				DstObject:     filepath.Base(path),
 			}
 		}
 		return nil
 	})
 }
 
-func UseCSVFile(csvPath string, filesChan chan<- SrcDest, errors chan<- SrcDest, newerThan time.Time) {
-	defer close(filesChan)
+// readCSVFile returns header and all not empty records of CSV file with
+// source and destination. Header is nil if CSV file has none.
+func readCSVFile(csvPath string) (header, [][]string) {
 	f, err := os.Open(csvPath)
 	if err != nil {
 		// nolint
 		log.Fatalf("error opening %s: %v", csvPath, err)
 	}
+	defer f.Close()
 	in := csv.NewReader(f)
 	recs, err := in.ReadAll()
 	if err != nil {
 		log.Fatalf("error opening %s: %v", csvPath, err)
 	}
+	h, err := parseHeader(recs)
+	if err != nil {
+		log.Fatalf("bad header of %s: %v", csvPath, err)
+	}
+	if h != nil {
+		recs = recs[1:]
+	}
+	result := make([][]string, 0, len(recs))
 	for i, rec := range recs {
+		if len(rec) < 2 {
+			log.Fatalf("record on line %v of %s should have source and destination", i+1, csvPath)
+		}
 		if len(rec[0]) == 0 && len(rec[1]) == 0 {
 			log.Debugf("found empty record on line %v", i+1)
 			continue
 		}
+		result = append(result, rec)
+	}
+	return h, result
+}
 
+func UseCSVFile(csvPath string, filesChan chan<- SrcDest, errors chan<- SrcDest, newerThan time.Time) {
+	defer close(filesChan)
+	h, recs := readCSVFile(csvPath)
+	for _, rec := range recs {
+		opts, err := h.options(rec, keyEncoding)
+		if err != nil {
+			errors <- SrcDest{
+				SourceFile: rec[0],
+				DstObject:  rec[1],
+				Error:      fmt.Errorf("bad options of %s: %w", rec[0], err),
+			}
+			continue
+		}
 		filePath, err := filepath.Abs(rec[0])
 		if err != nil {
 			errors <- SrcDest{
@@ -90,7 +169,7 @@ func UseCSVFile(csvPath string, filesChan chan<- SrcDest, errors chan<- SrcDest,
 			continue
 		}
 		rec[1] = replaceWildcard(rec[1], ext)
-		sum, size, err := getSizeAndSum(filePath)
+		sum, size, modTime, err := getSizeAndSum(filePath)
 		if err != nil {
 			errors <- SrcDest{
 				SourceFile: filePath,
@@ -99,35 +178,61 @@ func UseCSVFile(csvPath string, filesChan chan<- SrcDest, errors chan<- SrcDest,
 			}
 			continue
 		}
+		if opts != nil && len(opts.SHA256) != 0 && !strings.EqualFold(opts.SHA256, sum) {
+			errors <- SrcDest{
+				SourceFile:   filePath,
+				SourceSha256: sum,
+				SourceSize:   size,
+				DstObject:    rec[1],
+				Error:        fmt.Errorf("SHA-256 of %s is %s instead of expected %s", filePath, sum, opts.SHA256),
+			}
+			continue
+		}
 		if need2SkipOlderThan(filePath, newerThan) {
 			// we need to skip this file, because it's older than we require
 			continue
 		}
 		filesChan <- SrcDest{
-			SourceFile:   filePath,
-			SourceSha256: sum,
-			SourceSize:   size,
-			DstObject:    rec[1],
+			SourceFile:    filePath,
+			SourceSha256:  sum,
+			SourceSize:    size,
+			SourceModTime: modTime,
+			DstObject:     rec[1],
+			Options:       opts,
+		}
+	}
+}
+
+// UseObjectsCSVFile would read CSV file with S3 key and local path in each
+// record and would write them to filesChan
+func UseObjectsCSVFile(csvPath string, filesChan chan<- SrcDest) {
+	defer close(filesChan)
+	_, recs := readCSVFile(csvPath)
+	for _, rec := range recs {
+		filesChan <- SrcDest{
+			SourceFile: rec[0],
+			DstObject:  rec[1],
 		}
 	}
 }
 
-func getSizeAndSum(filePath string) (string, uint64, error) {
+func getSizeAndSum(filePath string) (string, uint64, time.Time, error) {
 	info, err := os.Stat(filePath)
 	if err != nil {
-		return "", 0, fmt.Errorf("can't get infor for %s: %w", filePath, err)
+		return "", 0, time.Time{}, fmt.Errorf("can't get infor for %s: %w", filePath, err)
 	}
 	f, err := os.Open(filePath)
 	if err != nil {
-		return "", 0, fmt.Errorf("can't open %s: %w", filePath, err)
+		return "", 0, time.Time{}, fmt.Errorf("can't open %s: %w", filePath, err)
 	}
+	defer f.Close()
 	buf := make([]byte, 1024*1024)
 	h := sha256.New()
-	if _, err := io.CopyBuffer(h, f, buf); err != nil {
-		return "", 0, fmt.Errorf("can't calculate sum for %s: %w", filePath, err)
+	if _, err := io.CopyBuffer(h, throttle.NewReader(f, hashLimiter), buf); err != nil {
+		return "", 0, time.Time{}, fmt.Errorf("can't calculate sum for %s: %w", filePath, err)
 	}
 	log.Debugf("%s size=%d sum256=%s", filePath, info.Size(), fmt.Sprintf("%x", h.Sum(nil)))
-	return fmt.Sprintf("%x", h.Sum(nil)), uint64(info.Size()), nil
+	return fmt.Sprintf("%x", h.Sum(nil)), uint64(info.Size()), info.ModTime(), nil
 }
 
 func prepareFilePath(filePath string) (string, string, error) {
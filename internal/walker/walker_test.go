@@ -64,10 +64,13 @@ func TestGetSizeAndSum(t *testing.T) {
 		assert.NoError(t, err)
 	}
 	tF.Close()
-	sum, size, err := getSizeAndSum(tF.Name())
+	sum, size, modTime, err := getSizeAndSum(tF.Name())
 	assert.NoError(t, err)
 	assert.Equal(t, sum, "6c62adc96b28bb8a141ca009f74ad345226c265806fe0eeecadcb524769f88c5")
 	assert.Equal(t, size, uint64(0x63e7000))
+	info, err := os.Stat(tF.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, info.ModTime(), modTime)
 }
 
 func TestUseCSVFile(t *testing.T) {
@@ -162,6 +165,10 @@ func TestUseCSVFile(t *testing.T) {
 					}
 					return
 				case f := <-fileList:
+					if len(tc.fileDetails.SourceFile) != 0 {
+						assert.False(t, f.SourceModTime.IsZero(), "Modification time should be set")
+						f.SourceModTime = time.Time{}
+					}
 					assert.Equal(t, tc.fileDetails, f, "Unexpected files channel message")
 					return
 				}